@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminAction is a change an admin can make to another user's account
+type adminAction struct {
+	event   AuditEventType
+	message string
+	apply   func(*User)
+}
+
+var adminActions = map[string]adminAction{
+	"lock": {AuditAccountLocked, "Locked %s", func(u *User) {
+		u.Locked = true
+		u.clearSession()
+	}},
+	"unlock": {AuditAccountUnlocked, "Unlocked %s", func(u *User) {
+		u.Locked = false
+	}},
+	"reset-password": {AuditPasswordResetRequired, "%s must choose a new password", func(u *User) {
+		u.MustChangePassword = true
+	}},
+	"revoke-sessions": {AuditSessionsRevoked, "Signed %s out", func(u *User) {
+		u.clearSession()
+	}},
+}
+
+// adminAllowed reports whether the request comes from a signed-in user with
+// the permission or carries the configured admin bearer token (for scripts)
+func adminAllowed(c *gin.Context, permission string) bool {
+	if token := config.Audit.AdminToken; token != "" {
+		if supplied, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+			return tokensEqual(supplied, token)
+		}
+	}
+
+	sessionToken, err := c.Cookie("session_token")
+	if err != nil {
+		return false
+	}
+	currentUser, exists := findUserBySession(c.Request.Context(), sessionToken)
+	return exists && !currentUser.Locked && currentUser.can(permission)
+}
+
+func adminConsole(c *gin.Context) {
+	currentUser, _ := getCurrentUser(c)
+	c.Header("Content-Type", "text/html")
+	c.String(http.StatusOK, adminPage(cspNonce(c), currentUser, listUsers(c.Request.Context()), popFlash(c)))
+}
+
+// adminUserAction handles POST /admin/users/:username/:action
+func adminUserAction(c *gin.Context) {
+	ctx := c.Request.Context()
+	currentUser, _ := getCurrentUser(c)
+	target := c.Param("username")
+
+	if !tokensEqual(c.PostForm("csrf_token"), currentUser.CSRFToken) {
+		logger.WarnContext(ctx, "Admin action failed - invalid CSRF token", "username", currentUser.Username, "client_ip", c.ClientIP())
+		audit(c, AuditCSRFFailed, currentUser.Username, "token_mismatch")
+		c.String(http.StatusForbidden, "Invalid CSRF token")
+		return
+	}
+
+	action, ok := adminActions[c.Param("action")]
+	if !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	// Locking yourself out could leave the app with no usable admin
+	if userKey(target) == userKey(currentUser.Username) && action.event == AuditAccountLocked {
+		redirectWithError(c, "/admin", "You can't lock your own account", "")
+		return
+	}
+
+	if err := updateUser(ctx, target, action.apply); err != nil {
+		redirectWithError(c, "/admin", "No such user", "")
+		return
+	}
+
+	logger.InfoContext(ctx, "Admin action", "action", action.event, "username", target, "actor", currentUser.Username)
+	auditAs(c, action.event, currentUser.Username, target, "")
+	setFlash(c, "info", fmt.Sprintf(action.message, target), "")
+	c.Redirect(http.StatusSeeOther, "/admin")
+}
+
+// runCreateAdmin creates the first admin, or promotes an existing user,
+// directly in the state file. Run it while the server is stopped, since the
+// server rewrites the state file on shutdown.
+func runCreateAdmin(args []string) error {
+	cfg, err := loadConfig(nil)
+	if err != nil {
+		return err
+	}
+	config = cfg
+
+	fs := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	username := fs.String("username", "", "admin username")
+	stateFile := fs.String("state-file", config.Store.StateFile, "state file the server loads users from")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *stateFile == "" {
+		return errors.New("a state file is required (-state-file or APP_STATE_FILE), otherwise the admin is lost before the server starts")
+	}
+	// Reserved names are allowed here, as only an operator can run this
+	*username = config.Policy.Username.Canonical(*username)
+	if err := config.Policy.Username.Validate(*username); err != nil {
+		return fmt.Errorf("invalid username: %w", err)
+	}
+	if err := loadState(*stateFile); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	reason := "promoted"
+	if _, exists := getUser(ctx, *username); exists {
+		if err := updateUser(ctx, *username, func(u *User) { u.Role = RoleAdmin }); err != nil {
+			return err
+		}
+	} else {
+		password, err := readAdminPassword()
+		if err != nil {
+			return err
+		}
+		if err := config.Policy.Password.Validate(*username, password); err != nil {
+			return fmt.Errorf("invalid password: %w", err)
+		}
+		if err := screenPassword(ctx, *username, password); err != nil {
+			return fmt.Errorf("invalid password: %w", err)
+		}
+		hashedPassword, err := hashPassword(ctx, password)
+		if err != nil {
+			return fmt.Errorf("hashing password: %w", err)
+		}
+		if err := createUser(ctx, &User{Username: *username, PasswordHash: hashedPassword, Role: RoleAdmin}); err != nil {
+			return err
+		}
+		reason = "created"
+	}
+
+	if err := saveState(*stateFile); err != nil {
+		return err
+	}
+	if config.Audit.File != "" {
+		a, err := openAuditLog(config.Audit.File, int64(config.Audit.MaxSizeMB)<<20)
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+		event := AuditEvent{Time: time.Now().UTC(), Type: AuditAdminCreated, Actor: "create-admin", Username: *username, Reason: reason}
+		if err := a.record(event); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("%s is now an admin (%s in %s)\n", *username, reason, *stateFile)
+	return nil
+}
+
+// readAdminPassword takes the password from ADMIN_PASSWORD, or else reads
+// one line from stdin so it doesn't end up in shell history
+func readAdminPassword() (string, error) {
+	if password, ok := os.LookupEnv("ADMIN_PASSWORD"); ok {
+		return password, nil
+	}
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("reading password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
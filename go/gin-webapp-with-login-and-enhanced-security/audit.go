@@ -0,0 +1,617 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuditEventType identifies what happened in an audit record
+type AuditEventType string
+
+const (
+	AuditRegistration       AuditEventType = "registration"
+	AuditRegistrationFailed AuditEventType = "registration_failed"
+	AuditLogin              AuditEventType = "login"
+	AuditLoginFailed        AuditEventType = "login_failed"
+	AuditLogout             AuditEventType = "logout"
+	AuditCSRFFailed         AuditEventType = "csrf_failed"
+	AuditPermissionDenied   AuditEventType = "permission_denied"
+
+	AuditPasswordChanged      AuditEventType = "password_changed"
+	AuditPasswordChangeFailed AuditEventType = "password_change_failed"
+
+	// Admin actions, recorded with the admin as Actor and the target as Username
+	AuditAdminCreated          AuditEventType = "admin_created"
+	AuditAccountLocked         AuditEventType = "account_locked"
+	AuditAccountUnlocked       AuditEventType = "account_unlocked"
+	AuditPasswordResetRequired AuditEventType = "password_reset_required"
+	AuditSessionsRevoked       AuditEventType = "sessions_revoked"
+
+	// First record of every file after a rotation, naming the file before it
+	AuditLogRotated AuditEventType = "audit_log_rotated"
+)
+
+// Rotated files are named <file>.<rotation time> so they sort in write order
+const auditRotatedLayout = "20060102T150405.000000000Z"
+
+const maxAuditQueryLimit = 1000
+
+// AuditEvent is one line of the audit log. Each record carries the hash of
+// the one before it, so editing, removing or reordering records breaks the
+// chain and is caught by verify-audit.
+type AuditEvent struct {
+	Seq       uint64         `json:"seq"`
+	Time      time.Time      `json:"time"`
+	Type      AuditEventType `json:"type"`
+	Actor     string         `json:"actor,omitempty"`
+	Username  string         `json:"username,omitempty"`
+	ClientIP  string         `json:"client_ip,omitempty"`
+	RequestID string         `json:"request_id,omitempty"`
+	Reason    string         `json:"reason,omitempty"`
+	PrevHash  string         `json:"prev_hash"`
+	Hash      string         `json:"hash,omitempty"`
+}
+
+// computeHash hashes the record's JSON encoding without its own hash
+func (e AuditEvent) computeHash() string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// auditHead is the last record written. It is kept in <file>.head so that
+// records cut from the end of the log are noticed, which the chain alone
+// can't show.
+type auditHead struct {
+	Seq  uint64 `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// readAuditHead reads the head kept beside path, or nil if there is none
+func readAuditHead(path string) (*auditHead, error) {
+	data, err := os.ReadFile(path + ".head")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var head auditHead
+	if err := json.Unmarshal(data, &head); err != nil {
+		return nil, fmt.Errorf("%s.head: %w", path, err)
+	}
+	return &head, nil
+}
+
+// auditLog appends hash-chained records to a JSON lines file, rotating it
+// once it grows past maxSize. The chain continues across rotated files.
+type auditLog struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	file     *os.File
+	head     *os.File
+	size     int64
+	seq      uint64
+	lastHash string
+}
+
+// auditor is the active audit log, nil when auditing is disabled
+var auditor *auditLog
+
+// rotatedAuditFiles lists the rotated files for path, oldest first
+func rotatedAuditFiles(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, match := range matches {
+		if _, err := time.Parse(auditRotatedLayout, strings.TrimPrefix(match, path+".")); err == nil {
+			files = append(files, match)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// auditFiles lists the rotated files for path, oldest first, followed by
+// path itself if it exists
+func auditFiles(path string) ([]string, error) {
+	files, err := rotatedAuditFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+	return files, nil
+}
+
+// readAuditFile calls fn for every record in the file
+func readAuditFile(path string, fn func(line int, event AuditEvent) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return readAuditRecords(f, path, fn)
+}
+
+// readAuditRecords calls fn for every record read from r, naming the file
+// in errors
+func readAuditRecords(r io.Reader, path string, fn func(line int, event AuditEvent) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var event AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return fmt.Errorf("%s:%d: invalid record: %w", path, line, err)
+		}
+		if err := fn(line, event); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// openAuditLog opens path for appending, picking the chain up from the last
+// record written by a previous run
+func openAuditLog(path string, maxSize int64) (*auditLog, error) {
+	a := &auditLog{path: path, maxSize: maxSize}
+
+	files, err := auditFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("listing audit files: %w", err)
+	}
+	for i := len(files) - 1; i >= 0 && a.seq == 0; i-- {
+		err := readAuditFile(files[i], func(_ int, event AuditEvent) error {
+			a.seq, a.lastHash = event.Seq, event.Hash
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("reading audit log: %w", err)
+		}
+	}
+
+	// Starting over from a shorter log would hide the missing records
+	// behind new ones that chain correctly
+	head, err := readAuditHead(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading audit head: %w", err)
+	}
+	if head != nil && head.Seq > a.seq {
+		return nil, fmt.Errorf("audit log ends at record %d but record %d was written; run verify-audit", a.seq, head.Seq)
+	}
+
+	a.head, err = os.OpenFile(path+".head", os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening audit head: %w", err)
+	}
+	if err := a.openFile(); err != nil {
+		a.head.Close()
+		return nil, err
+	}
+	if err := a.writeHead(); err != nil {
+		a.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *auditLog) openFile() error {
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("opening audit log: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("opening audit log: %w", err)
+	}
+	a.file, a.size = f, info.Size()
+	return nil
+}
+
+// writeHead records the last record written. Sequence numbers only grow, so
+// each write covers the one before it.
+func (a *auditLog) writeHead() error {
+	data, _ := json.Marshal(auditHead{Seq: a.seq, Hash: a.lastHash})
+	if _, err := a.head.WriteAt(data, 0); err != nil {
+		return fmt.Errorf("writing audit head: %w", err)
+	}
+	return nil
+}
+
+// rotate moves the current file aside and starts a new one whose first
+// record names it. If that fails the current file is reopened, so records
+// keep being written to it rather than lost.
+func (a *auditLog) rotate() error {
+	rotated := a.path + "." + time.Now().UTC().Format(auditRotatedLayout)
+	err := a.file.Close()
+	a.file = nil
+	if err != nil {
+		err = fmt.Errorf("closing audit log: %w", err)
+	} else if err = os.Rename(a.path, rotated); err != nil {
+		err = fmt.Errorf("rotating audit log: %w", err)
+	}
+	if openErr := a.openFile(); openErr != nil {
+		return errors.Join(err, openErr)
+	}
+	if err != nil {
+		return err
+	}
+	return a.append(AuditEvent{
+		Time:   time.Now().UTC(),
+		Type:   AuditLogRotated,
+		Reason: filepath.Base(rotated),
+	})
+}
+
+// encode fills in the event's sequence number and hashes as the next record
+func (a *auditLog) encode(event AuditEvent) (AuditEvent, []byte, error) {
+	event.Seq = a.seq + 1
+	event.PrevHash = a.lastHash
+	event.Hash = event.computeHash()
+	data, err := json.Marshal(event)
+	if err != nil {
+		return event, nil, fmt.Errorf("encoding audit record: %w", err)
+	}
+	return event, append(data, '\n'), nil
+}
+
+// append writes the event as the next record of the current file
+func (a *auditLog) append(event AuditEvent) error {
+	event, data, err := a.encode(event)
+	if err != nil {
+		return err
+	}
+	if _, err := a.file.Write(data); err != nil {
+		return fmt.Errorf("writing audit log: %w", err)
+	}
+	a.size += int64(len(data))
+	a.seq, a.lastHash = event.Seq, event.Hash
+	return a.writeHead()
+}
+
+// record appends the event, filling in its sequence number and hashes
+func (a *auditLog) record(event AuditEvent) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		// An earlier rotation couldn't reopen the file
+		if err := a.openFile(); err != nil {
+			return err
+		}
+	}
+
+	_, data, err := a.encode(event)
+	if err != nil {
+		return err
+	}
+	if a.size > 0 && a.size+int64(len(data)) > a.maxSize {
+		if err := a.rotate(); err != nil {
+			if a.file == nil {
+				return err
+			}
+			logger.Error("Audit log rotation failed, appending to the current file", "error", err.Error())
+		}
+	}
+	return a.append(event)
+}
+
+func (a *auditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var errs []error
+	if a.file != nil {
+		errs = append(errs, a.file.Close())
+	}
+	errs = append(errs, a.head.Close())
+	return errors.Join(errs...)
+}
+
+// check reports an error when records can no longer be written where they
+// belong: the file couldn't be reopened after a rotation, or was removed or
+// replaced on disk
+func (a *auditLog) check() error {
+	if !tryLockFor(a.mu.TryLock, 100*time.Millisecond) {
+		return errors.New("audit log lock not available")
+	}
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		return errors.New("audit log file not open")
+	}
+	open, err := a.file.Stat()
+	if err != nil {
+		return fmt.Errorf("audit log file: %w", err)
+	}
+	onDisk, err := os.Stat(a.path)
+	if err != nil {
+		return fmt.Errorf("audit log file: %w", err)
+	}
+	if !os.SameFile(open, onDisk) {
+		return errors.New("audit log file was replaced on disk")
+	}
+	return nil
+}
+
+// initAudit opens the configured audit log and closes it on shutdown
+func initAudit() error {
+	if config.Audit.File == "" {
+		return nil
+	}
+	a, err := openAuditLog(config.Audit.File, int64(config.Audit.MaxSizeMB)<<20)
+	if err != nil {
+		return err
+	}
+	auditor = a
+	hooks.OnShutdown("audit log", func(context.Context) error { return a.Close() })
+
+	logger.Info("Audit log opened", "path", a.path, "seq", a.seq, "head", a.lastHash)
+	return nil
+}
+
+// audit records a security event for the current request. Failures to write
+// are logged but never fail the request.
+func audit(c *gin.Context, eventType AuditEventType, username, reason string) {
+	auditAs(c, eventType, "", username, reason)
+}
+
+// auditAs records an event performed by actor on another user's account
+func auditAs(c *gin.Context, eventType AuditEventType, actor, username, reason string) {
+	if auditor == nil {
+		return
+	}
+	event := AuditEvent{
+		Time:      time.Now().UTC(),
+		Type:      eventType,
+		Actor:     actor,
+		Username:  username,
+		ClientIP:  c.ClientIP(),
+		RequestID: c.GetString("request_id"),
+		Reason:    reason,
+	}
+	if err := auditor.record(event); err != nil {
+		logger.ErrorContext(c.Request.Context(), "Failed to write audit record", "type", eventType, "error", err.Error())
+	}
+}
+
+// auditFilter selects records for the admin query endpoint
+type auditFilter struct {
+	Username string
+	Actor    string
+	ClientIP string
+	Type     AuditEventType
+	Since    time.Time
+	Until    time.Time
+}
+
+func (f auditFilter) matches(event AuditEvent) bool {
+	return (f.Username == "" || event.Username == f.Username) &&
+		(f.Actor == "" || event.Actor == f.Actor) &&
+		(f.ClientIP == "" || event.ClientIP == f.ClientIP) &&
+		(f.Type == "" || event.Type == f.Type) &&
+		(f.Since.IsZero() || !event.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || event.Time.Before(f.Until))
+}
+
+// query returns up to limit matching records, newest first. The lock is only
+// held to list the files and open the current one, which keeps it readable
+// through a rotation; reading starts at the newest file and stops once limit
+// records have matched.
+func (a *auditLog) query(filter auditFilter, limit int) ([]AuditEvent, error) {
+	a.mu.Lock()
+	rotated, err := rotatedAuditFiles(a.path)
+	var current *os.File
+	if err == nil && a.file != nil {
+		current, err = os.Open(a.path)
+	}
+	// Anything past size is a record still being written
+	size := a.size
+	a.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []AuditEvent
+	// collect adds a file's matches, newest first, keeping no more than limit
+	collect := func(r io.Reader, path string) error {
+		var found []AuditEvent
+		err := readAuditRecords(r, path, func(_ int, event AuditEvent) error {
+			if filter.matches(event) {
+				found = append(found, event)
+				if len(found) > limit {
+					found = found[1:]
+				}
+			}
+			return nil
+		})
+		for i := len(found) - 1; i >= 0 && len(matched) < limit; i-- {
+			matched = append(matched, found[i])
+		}
+		return err
+	}
+
+	if current != nil {
+		err := collect(io.LimitReader(current, size), a.path)
+		current.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i := len(rotated) - 1; i >= 0 && len(matched) < limit; i-- {
+		f, err := os.Open(rotated[i])
+		if err != nil {
+			return nil, err
+		}
+		err = collect(f, rotated[i])
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return matched, nil
+}
+
+func parseAuditFilter(c *gin.Context) (auditFilter, int, error) {
+	filter := auditFilter{
+		Username: c.Query("user"),
+		Actor:    c.Query("actor"),
+		ClientIP: c.Query("ip"),
+		Type:     AuditEventType(c.Query("type")),
+	}
+	limit := 100
+
+	var err error
+	if v := c.Query("since"); v != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, 0, errors.New("since must be an RFC 3339 time")
+		}
+	}
+	if v := c.Query("until"); v != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, 0, errors.New("until must be an RFC 3339 time")
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > maxAuditQueryLimit {
+			return filter, 0, fmt.Errorf("limit must be between 1 and %d", maxAuditQueryLimit)
+		}
+	}
+	return filter, limit, nil
+}
+
+// auditQueryHandler serves GET /admin/audit?user=&actor=&ip=&type=&since=&until=&limit=
+func auditQueryHandler(c *gin.Context) {
+	if !adminAllowed(c, PermAuditRead) {
+		logger.WarnContext(c.Request.Context(), "Audit query denied", "client_ip", c.ClientIP())
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if auditor == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "audit log is disabled"})
+		return
+	}
+
+	filter, limit, err := parseAuditFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	events, err := auditor.query(filter, limit)
+	if err != nil {
+		logger.ErrorContext(c.Request.Context(), "Audit query failed", "error", err.Error())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read audit log"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"events": events, "count": len(events)})
+}
+
+// verifyAuditChain checks every record's hash and its link to the previous
+// record across all files, returning the number of records and the last one.
+// The chain must start at the first record or, when older files have been
+// removed, at the start of a rotated file, and must reach head if given.
+func verifyAuditChain(files []string, head *auditHead) (int, *AuditEvent, error) {
+	count := 0
+	var prev *AuditEvent
+	for _, file := range files {
+		err := readAuditFile(file, func(line int, event AuditEvent) error {
+			if event.computeHash() != event.Hash {
+				return fmt.Errorf("%s:%d: record %d has been modified", file, line, event.Seq)
+			}
+			if prev == nil && event.Seq == 1 && event.PrevHash != "" {
+				return fmt.Errorf("%s:%d: first record has a previous hash", file, line)
+			}
+			if prev == nil && event.Seq != 1 && (line != 1 || event.Type != AuditLogRotated) {
+				return fmt.Errorf("%s:%d: records before %d are missing", file, line, event.Seq)
+			}
+			if head != nil && event.Seq == head.Seq && event.Hash != head.Hash {
+				return fmt.Errorf("%s:%d: record %d does not match the head file", file, line, event.Seq)
+			}
+			if prev != nil {
+				if event.Seq != prev.Seq+1 {
+					return fmt.Errorf("%s:%d: expected record %d, found %d", file, line, prev.Seq+1, event.Seq)
+				}
+				if event.PrevHash != prev.Hash {
+					return fmt.Errorf("%s:%d: record %d does not follow record %d", file, line, event.Seq, prev.Seq)
+				}
+			}
+			count++
+			prev = &event
+			return nil
+		})
+		if err != nil {
+			return count, prev, err
+		}
+	}
+	var last uint64
+	if prev != nil {
+		last = prev.Seq
+	}
+	if head != nil && last < head.Seq {
+		return count, prev, fmt.Errorf("log ends at record %d but record %d was written", last, head.Seq)
+	}
+	return count, prev, nil
+}
+
+func runVerifyAudit(args []string) error {
+	fs := flag.NewFlagSet("verify-audit", flag.ContinueOnError)
+	path := fs.String("file", "audit.log", "audit log to verify, along with its rotated files")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	files, err := auditFiles(*path)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no audit log found at %s", *path)
+	}
+
+	written, err := readAuditHead(*path)
+	if err != nil {
+		return err
+	}
+	if written == nil {
+		return fmt.Errorf("no %s.head file, so records removed from the end can't be detected", *path)
+	}
+
+	count, head, err := verifyAuditChain(files, written)
+	if err != nil {
+		return fmt.Errorf("audit chain broken after %d valid records: %w", count, err)
+	}
+	if count == 0 {
+		fmt.Println("Audit log is empty")
+		return nil
+	}
+
+	fmt.Printf("Verified %d records in %d files, head is record %d (%s)\n", count, len(files), head.Seq, head.Hash)
+	if first := head.Seq - uint64(count) + 1; first != 1 {
+		fmt.Printf("The chain starts at record %d; earlier files are no longer present\n", first)
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openTestAudit opens an audit log in a temporary directory that rotates
+// after every couple of records
+func openTestAudit(t *testing.T) *auditLog {
+	t.Helper()
+	logger = slog.New(slog.DiscardHandler)
+	a, err := openAuditLog(filepath.Join(t.TempDir(), "audit.log"), 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { a.Close() })
+	return a
+}
+
+// recordLogins writes a login for user0 up to user<n-1>
+func recordLogins(t *testing.T, a *auditLog, n int) {
+	t.Helper()
+	for i := range n {
+		event := AuditEvent{Time: time.Now().UTC(), Type: AuditLogin, Username: fmt.Sprintf("user%d", i)}
+		if err := a.record(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// auditChain lists the log's files and reads its head
+func auditChain(t *testing.T, path string) ([]string, *auditHead) {
+	t.Helper()
+	files, err := auditFiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	head, err := readAuditHead(path)
+	if err != nil || head == nil {
+		t.Fatalf("reading head: %v, %v", head, err)
+	}
+	return files, head
+}
+
+// rewriteFile replaces the first occurrence of old in the file
+func rewriteFile(t *testing.T, path, old, new string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), old) {
+		t.Fatalf("%s doesn't contain %q", path, old)
+	}
+	if err := os.WriteFile(path, []byte(strings.Replace(string(data), old, new, 1)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// firstLine returns everything up to and including the file's first newline
+func firstLine(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, _, _ := strings.Cut(string(data), "\n")
+	return line + "\n"
+}
+
+func TestAuditChainVerifiesAcrossRotations(t *testing.T) {
+	a := openTestAudit(t)
+	recordLogins(t, a, 10)
+
+	files, head := auditChain(t, a.path)
+	if len(files) < 3 {
+		t.Fatalf("got %d files, want the log rotated more than once", len(files))
+	}
+	count, last, err := verifyAuditChain(files, head)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every file after the first opens with a rotation record
+	if want := 10 + len(files) - 1; count != want {
+		t.Errorf("verified %d records, want %d", count, want)
+	}
+	if last.Seq != head.Seq || last.Hash != head.Hash || last.Seq != a.seq {
+		t.Errorf("chain ends at %d (%s), head is %d (%s)", last.Seq, last.Hash, head.Seq, head.Hash)
+	}
+	if line := firstLine(t, files[1]); !strings.Contains(line, `"type":"audit_log_rotated"`) ||
+		!strings.Contains(line, filepath.Base(files[0])) {
+		t.Errorf("second file starts with %s, want a rotation record naming %s", line, filepath.Base(files[0]))
+	}
+}
+
+func TestAuditChainDetectsEditedRecord(t *testing.T) {
+	a := openTestAudit(t)
+	recordLogins(t, a, 10)
+	files, head := auditChain(t, a.path)
+
+	rewriteFile(t, files[0], `"type":"login"`, `"type":"logout"`)
+	if _, _, err := verifyAuditChain(files, head); err == nil || !strings.Contains(err.Error(), "has been modified") {
+		t.Errorf("got %v, want the edited record reported", err)
+	}
+}
+
+func TestAuditChainDetectsRecordsCutFromTheEnd(t *testing.T) {
+	a := openTestAudit(t)
+	recordLogins(t, a, 10)
+	files, head := auditChain(t, a.path)
+
+	// Dropping the newest file leaves a chain that is intact on its own;
+	// only the head shows records are missing
+	if _, _, err := verifyAuditChain(files[:len(files)-1], nil); err != nil {
+		t.Fatalf("shortened chain didn't verify on its own: %v", err)
+	}
+	_, _, err := verifyAuditChain(files[:len(files)-1], head)
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("record %d was written", head.Seq)) {
+		t.Errorf("got %v, want the missing records reported", err)
+	}
+}
+
+func TestAuditChainMissingOldestFile(t *testing.T) {
+	a := openTestAudit(t)
+	recordLogins(t, a, 10)
+	files, head := auditChain(t, a.path)
+
+	// Pruning old files is allowed, as the next one opens with a rotation
+	// record
+	count, _, err := verifyAuditChain(files[1:], head)
+	if err != nil {
+		t.Fatalf("chain without its oldest file: %v", err)
+	}
+	if count == 0 {
+		t.Fatal("verified no records")
+	}
+
+	// but a file that starts anywhere else has lost records
+	rewriteFile(t, files[1], firstLine(t, files[1]), "")
+	if _, _, err := verifyAuditChain(files[1:], head); err == nil || !strings.Contains(err.Error(), "are missing") {
+		t.Errorf("got %v, want the missing records reported", err)
+	}
+}
+
+func TestOpenAuditLogRefusesShortenedLog(t *testing.T) {
+	a := openTestAudit(t)
+	recordLogins(t, a, 10)
+	a.Close()
+	files, _ := auditChain(t, a.path)
+
+	if err := os.Remove(files[len(files)-1]); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := openAuditLog(a.path, a.maxSize)
+	if err == nil {
+		reopened.Close()
+		t.Fatal("reopened a log missing its newest records")
+	}
+	if !strings.Contains(err.Error(), "run verify-audit") {
+		t.Errorf("got %v, want a pointer to verify-audit", err)
+	}
+}
+
+func TestOpenAuditLogContinuesTheChain(t *testing.T) {
+	a := openTestAudit(t)
+	recordLogins(t, a, 3)
+	seq := a.seq
+	a.Close()
+
+	reopened, err := openAuditLog(a.path, a.maxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	recordLogins(t, reopened, 3)
+	if reopened.seq <= seq+3 {
+		t.Errorf("reopened log is at record %d, want it past %d", reopened.seq, seq+3)
+	}
+	files, head := auditChain(t, a.path)
+	if _, _, err := verifyAuditChain(files, head); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAuditQueryNewestFirst(t *testing.T) {
+	a := openTestAudit(t)
+	recordLogins(t, a, 10)
+	if files, _ := auditChain(t, a.path); len(files) < 3 {
+		t.Fatalf("got %d files, want records spread over several", len(files))
+	}
+
+	events, err := a.query(auditFilter{Type: AuditLogin}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, event := range events {
+		got = append(got, event.Username)
+	}
+	if want := "user9 user8 user7 user6 user5"; strings.Join(got, " ") != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+
+	events, err = a.query(auditFilter{}, maxAuditQueryLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uint64(len(events)) != a.seq {
+		t.Fatalf("got %d records, want all %d", len(events), a.seq)
+	}
+	for i, event := range events {
+		if want := a.seq - uint64(i); event.Seq != want {
+			t.Fatalf("record %d is %d, want %d", i, event.Seq, want)
+		}
+	}
+
+	events, err = a.query(auditFilter{Username: "user3"}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Username != "user3" {
+		t.Errorf("got %v, want the one record for user3", events)
+	}
+}
+
+func TestAuditLogCheck(t *testing.T) {
+	a := openTestAudit(t)
+	if err := a.check(); err != nil {
+		t.Fatalf("fresh log failed its check: %v", err)
+	}
+	if err := os.Rename(a.path, a.path+".moved"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.check(); err == nil {
+		t.Error("a log moved out from under the server passed its check")
+	}
+}
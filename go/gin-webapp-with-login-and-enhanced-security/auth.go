@@ -1,28 +1,20 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
 	"errors"
 	"net/http"
-	"regexp"
-	"strings"
-	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
-	"golang.org/x/crypto/bcrypt"
-)
-
-type User struct {
-	Username     string
-	PasswordHash string
-	SessionToken string
-	CSRFToken    string
-}
-
-var (
-	users      = make(map[string]*User)
-	usersMutex sync.RWMutex
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"shared/authpolicy"
+	"shared/passhash"
 )
 
 func generateToken(length int) string {
@@ -34,165 +26,328 @@ func generateToken(length int) string {
 	return base64.URLEncoding.EncodeToString(bytes)
 }
 
-func hashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(bytes), err
+// tokensEqual compares a submitted token with the stored one in constant
+// time, so response times don't reveal how much of a guess was right
+func tokensEqual(submitted, stored string) bool {
+	return subtle.ConstantTimeCompare([]byte(submitted), []byte(stored)) == 1
+}
+
+// hashPassword hashes the canonical form of password once a hashing
+// worker is free
+func hashPassword(ctx context.Context, password string) (string, error) {
+	hasher := config.Password.Hasher()
+	ctx, span := tracer.Start(ctx, "password.hash", trace.WithAttributes(attribute.String("password.algorithm", config.Password.Algorithm)))
+	defer span.End()
+
+	var hash string
+	var err error
+	if poolErr := hashing.Do(ctx, func() { hash, err = hasher.Hash(config.Policy.Password.Canonical(password)) }); poolErr != nil {
+		return "", poolErr
+	}
+	return hash, err
+}
+
+// checkPassword verifies the password against a hash from any supported
+// algorithm. needsRehash is set when it matched but the hash uses another
+// algorithm or outdated parameters, so the caller can store a fresh one.
+// err is only set when no hashing worker could be had.
+func checkPassword(ctx context.Context, hash, password string) (match, needsRehash bool, err error) {
+	ctx, span := tracer.Start(ctx, "password.check")
+	defer span.End()
+
+	current := config.Password.Hasher()
+	err = hashing.Do(ctx, func() {
+		canonical := config.Policy.Password.Canonical(password)
+		match, needsRehash = passhash.Verify(current, hash, canonical)
+		if !match && canonical != password {
+			// Hashed before the password was normalized
+			if match, _ = passhash.Verify(current, hash, password); match {
+				needsRehash = true
+			}
+		}
+	})
+	return match, needsRehash, err
+}
+
+// rehashPassword replaces an outdated hash after a successful login, unless
+// the password was changed in the meantime
+func rehashPassword(ctx context.Context, username, oldHash, password string) {
+	newHash, err := hashPassword(ctx, password)
+	if err != nil {
+		logger.WarnContext(ctx, "Failed to upgrade password hash", "username", username, "error", err.Error())
+		return
+	}
+	updateUser(ctx, username, func(u *User) {
+		if u.PasswordHash == oldHash {
+			u.PasswordHash = newHash
+		}
+	})
+	logger.InfoContext(ctx, "Upgraded password hash", "username", username, "algorithm", config.Password.Algorithm)
 }
 
-func checkPassword(hash, password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+var errBreachedPassword = errors.New("this password has appeared in a data breach, so attackers will try it first; please choose another")
+
+// weakPasswordError carries the estimator's explanation to the user
+type weakPasswordError struct {
+	strength Strength
 }
 
-// Input validation functions
-func validateUsername(username string) error {
-	username = strings.TrimSpace(username)
-	
-	if len(username) < 3 {
-		return errors.New("username must be at least 3 characters long")
+func (e *weakPasswordError) Error() string {
+	msg := "this password is too easy to guess"
+	if e.strength.Warning != "" {
+		msg += ". " + e.strength.Warning
 	}
-	
-	if len(username) > 50 {
-		return errors.New("username must be no more than 50 characters long")
+	return msg + ". Add another word or two; uncommon words are better than symbols or digits"
+}
+
+// screenPassword rejects passwords found in the breach corpus or scored
+// below the configured strength. Corpus read errors are logged and the
+// check skipped, so a broken corpus doesn't block every registration.
+// Scoring costs about as much as a hash, so it runs on a hashing worker
+// and fails like hashPassword when none can be had.
+func screenPassword(ctx context.Context, username, password string) error {
+	if config.Password.BreachCorpus != "" {
+		count, err := breachCount(ctx, password)
+		if err != nil {
+			logger.ErrorContext(ctx, "Breached password lookup failed", "error", err.Error())
+		} else if count >= config.Password.BreachMinCount {
+			return errBreachedPassword
+		}
 	}
-	
-	// Allow only alphanumeric characters, underscores, and hyphens
-	validUsername := regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
-	if !validUsername.MatchString(username) {
-		return errors.New("username can only contain letters, numbers, underscores, and hyphens")
+
+	if config.Password.MinStrength > 0 {
+		var strength Strength
+		if err := hashing.Do(ctx, func() { strength = estimateStrength(password, username) }); err != nil {
+			return err
+		}
+		if strength.Score < config.Password.MinStrength {
+			return &weakPasswordError{strength}
+		}
 	}
-	
 	return nil
 }
 
-func validatePassword(password string) error {
-	if len(password) < 8 {
-		return errors.New("password must be at least 8 characters long")
-	}
-	
-	if len(password) > 128 {
-		return errors.New("password must be no more than 128 characters long")
-	}
-	
-	// Check for at least one uppercase letter
-	hasUpper := regexp.MustCompile(`[A-Z]`).MatchString(password)
-	// Check for at least one lowercase letter
-	hasLower := regexp.MustCompile(`[a-z]`).MatchString(password)
-	// Check for at least one digit
-	hasNumber := regexp.MustCompile(`[0-9]`).MatchString(password)
-	
-	if !hasUpper || !hasLower || !hasNumber {
-		return errors.New("password must contain at least one uppercase letter, one lowercase letter, and one number")
-	}
-	
-	return nil
+// tooLong reports whether s has more than limit characters; 0 is no limit
+func tooLong(s string, limit int) bool {
+	return limit != 0 && utf8.RuneCountInString(s) > limit
+}
+
+// passwordRejectReason names a password policy or screenPassword failure
+// for the audit log
+func passwordRejectReason(err error) string {
+	var weak *weakPasswordError
+	switch {
+	case errors.Is(err, errBreachedPassword):
+		return "breached_password"
+	case errors.As(err, &weak):
+		return "weak_password"
+	}
+	return "invalid_password"
+}
+
+// policyHandler publishes the username and password rules, including the
+// screening that happens on top of them
+func policyHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, struct {
+		authpolicy.Config
+		MinStrength int  `json:"min_strength"`
+		BreachCheck bool `json:"breach_check"`
+	}{config.Policy, config.Password.MinStrength, config.Password.BreachCorpus != ""})
 }
 
 func registerUser(c *gin.Context) {
-	username := strings.TrimSpace(c.PostForm("username"))
+	ctx := c.Request.Context()
+	username := config.Policy.Username.Canonical(c.PostForm("username"))
 	password := c.PostForm("password")
 
 	if username == "" || password == "" {
-		logger.Warn("Registration failed - missing credentials", "client_ip", c.ClientIP())
-		c.String(http.StatusBadRequest, "Username and password are required")
+		logger.WarnContext(ctx, "Registration failed - missing credentials", "client_ip", c.ClientIP())
+		registrationsTotal.WithLabelValues("invalid_input").Inc()
+		redirectWithError(c, "/register", "Username and password are required", username)
+		audit(c, AuditRegistrationFailed, username, "missing_credentials")
 		return
 	}
 
 	// Validate username
-	if err := validateUsername(username); err != nil {
-		logger.Warn("Registration failed - invalid username", "username", username, "error", err.Error(), "client_ip", c.ClientIP())
-		c.String(http.StatusBadRequest, "Invalid username: "+err.Error())
+	if err := config.Policy.Username.Validate(username); err != nil {
+		logger.WarnContext(ctx, "Registration failed - invalid username", "username", username, "error", err.Error(), "client_ip", c.ClientIP())
+		registrationsTotal.WithLabelValues("invalid_input").Inc()
+		redirectWithError(c, "/register", "Invalid username: "+err.Error(), username)
+		audit(c, AuditRegistrationFailed, username, "invalid_username")
 		return
 	}
 
-	// Validate password
-	if err := validatePassword(password); err != nil {
-		logger.Warn("Registration failed - invalid password", "username", username, "error", err.Error(), "client_ip", c.ClientIP())
-		c.String(http.StatusBadRequest, "Invalid password: "+err.Error())
+	// Reserved names (and lookalikes) are only for create-admin
+	if config.Policy.Username.IsReserved(username) {
+		logger.WarnContext(ctx, "Registration failed - reserved username", "username", username, "client_ip", c.ClientIP())
+		registrationsTotal.WithLabelValues("invalid_input").Inc()
+		redirectWithError(c, "/register", "Invalid username: that name is reserved", username)
+		audit(c, AuditRegistrationFailed, username, "reserved_username")
 		return
 	}
 
-	usersMutex.Lock()
-	defer usersMutex.Unlock()
+	// Validate password
+	err := config.Policy.Password.Validate(username, password)
+	if err == nil {
+		err = screenPassword(ctx, username, password)
+	}
+	if hashingRefused(err) {
+		logger.WarnContext(ctx, "Registration failed - password hashing busy", "username", username, "client_ip", c.ClientIP(), "error", err.Error())
+		registrationsTotal.WithLabelValues("busy").Inc()
+		audit(c, AuditRegistrationFailed, username, "busy")
+		respondHashingBusy(c)
+		return
+	}
+	if err != nil {
+		reason := passwordRejectReason(err)
+		logger.WarnContext(ctx, "Registration failed - invalid password", "username", username, "reason", reason, "client_ip", c.ClientIP())
+		registrationsTotal.WithLabelValues("invalid_input").Inc()
+		redirectWithError(c, "/register", "Invalid password: "+err.Error(), username)
+		audit(c, AuditRegistrationFailed, username, reason)
+		return
+	}
 
-	if _, exists := users[username]; exists {
-		logger.Warn("Registration failed - user already exists", "username", username, "client_ip", c.ClientIP())
-		c.Redirect(http.StatusSeeOther, "/register")
+	if _, exists := getUser(ctx, username); exists {
+		logger.WarnContext(ctx, "Registration failed - user already exists", "username", username, "client_ip", c.ClientIP())
+		registrationsTotal.WithLabelValues("user_exists").Inc()
+		redirectWithError(c, "/register", "That username is already taken", username)
+		audit(c, AuditRegistrationFailed, username, "user_exists")
 		return
 	}
 
-	hashedPassword, err := hashPassword(password)
+	hashedPassword, err := hashPassword(ctx, password)
+	if hashingRefused(err) {
+		logger.WarnContext(ctx, "Registration failed - password hashing busy", "username", username, "client_ip", c.ClientIP(), "error", err.Error())
+		registrationsTotal.WithLabelValues("busy").Inc()
+		audit(c, AuditRegistrationFailed, username, "busy")
+		respondHashingBusy(c)
+		return
+	}
+	if errors.Is(err, passhash.ErrPasswordTooLong) {
+		registrationsTotal.WithLabelValues("invalid_input").Inc()
+		redirectWithError(c, "/register", "Invalid password: password must be no more than 72 bytes", username)
+		audit(c, AuditRegistrationFailed, username, "invalid_password")
+		return
+	}
 	if err != nil {
-		logger.Error("Registration failed - password hashing error", "username", username, "client_ip", c.ClientIP(), "error", err.Error())
-		c.Redirect(http.StatusSeeOther, "/register")
+		logger.ErrorContext(ctx, "Registration failed - password hashing error", "username", username, "client_ip", c.ClientIP(), "error", err.Error())
+		registrationsTotal.WithLabelValues("error").Inc()
+		redirectWithError(c, "/register", "Something went wrong, please try again", username)
+		audit(c, AuditRegistrationFailed, username, "error")
 		return
 	}
 
-	users[username] = &User{
-		Username:     username,
-		PasswordHash: hashedPassword,
+	// The name may have been taken while the password was hashing
+	err = createUser(ctx, &User{Username: username, PasswordHash: hashedPassword, Role: RoleUser})
+	if errors.Is(err, errUsernameConfusable) {
+		logger.WarnContext(ctx, "Registration failed - username looks like an existing one", "username", username, "client_ip", c.ClientIP())
+		registrationsTotal.WithLabelValues("invalid_input").Inc()
+		redirectWithError(c, "/register", "Invalid username: it looks too similar to an existing account", username)
+		audit(c, AuditRegistrationFailed, username, "confusable_username")
+		return
+	}
+	if err != nil {
+		logger.WarnContext(ctx, "Registration failed - user already exists", "username", username, "client_ip", c.ClientIP())
+		registrationsTotal.WithLabelValues("user_exists").Inc()
+		redirectWithError(c, "/register", "That username is already taken", username)
+		audit(c, AuditRegistrationFailed, username, "user_exists")
+		return
 	}
 
-	logger.Info("User registered successfully", "username", username, "client_ip", c.ClientIP())
+	setLogUser(c, username)
+	logger.InfoContext(ctx, "User registered successfully", "username", username, "client_ip", c.ClientIP())
+	registrationsTotal.WithLabelValues("success").Inc()
+	audit(c, AuditRegistration, username, "")
 
 	// After successful registration, log them in automatically
-	sessionToken := generateToken(32)
-	csrfToken := generateToken(32)
-
-	users[username].SessionToken = sessionToken
-	users[username].CSRFToken = csrfToken
+	sessionToken := generateToken(config.Session.TokenLength)
+	csrfToken := generateToken(config.Session.TokenLength)
+	startSession(ctx, username, sessionToken, csrfToken)
 
 	// Set session cookie with secure flag for HTTPS
-	c.SetCookie("session_token", sessionToken, 3600*24, "/", "", true, true)
-	c.SetCookie("csrf_token", csrfToken, 3600*24, "/", "", true, false)
+	c.SetCookie("session_token", sessionToken, int(config.Session.CookieMaxAge.Seconds()), "/", "", true, true)
+	c.SetCookie("csrf_token", csrfToken, int(config.Session.CookieMaxAge.Seconds()), "/", "", true, false)
 
 	c.Redirect(http.StatusSeeOther, "/protected-page")
 }
 
 func loginUser(c *gin.Context) {
-	username := strings.TrimSpace(c.PostForm("username"))
+	ctx := c.Request.Context()
+	username := config.Policy.Username.Canonical(c.PostForm("username"))
 	password := c.PostForm("password")
 
 	if username == "" || password == "" {
-		logger.Warn("Login failed - missing credentials", "client_ip", c.ClientIP())
-		c.String(http.StatusBadRequest, "Username and password are required")
+		logger.WarnContext(ctx, "Login failed - missing credentials", "client_ip", c.ClientIP())
+		loginAttemptsTotal.WithLabelValues("invalid_input").Inc()
+		redirectWithError(c, "/login", "Username and password are required", username)
+		audit(c, AuditLoginFailed, username, "missing_credentials")
 		return
 	}
 
 	// Basic validation for login (less strict than registration)
-	if len(username) > 50 || len(password) > 128 {
-		logger.Warn("Login failed - input too long", "username", username, "client_ip", c.ClientIP())
-		c.String(http.StatusBadRequest, "Invalid input length")
+	if tooLong(username, config.Policy.Username.MaxLength) || tooLong(password, config.Policy.Password.MaxLength) {
+		logger.WarnContext(ctx, "Login failed - input too long", "username", username, "client_ip", c.ClientIP())
+		loginAttemptsTotal.WithLabelValues("invalid_input").Inc()
+		redirectWithError(c, "/login", "Invalid input length", "")
+		audit(c, AuditLoginFailed, "", "input_too_long")
 		return
 	}
 
-	usersMutex.RLock()
-	user, exists := users[username]
-	usersMutex.RUnlock()
-
-	if !exists || !checkPassword(user.PasswordHash, password) {
-		logger.Warn("Login failed", "username", username, "client_ip", c.ClientIP())
-		c.Redirect(http.StatusSeeOther, "/login")
+	// Unknown users are checked against a dummy hash, so they take as long
+	// to turn away as a wrong password and can't be told apart by timing
+	user, exists := getUser(ctx, username)
+	hash := dummyHash()
+	if exists {
+		hash = user.PasswordHash
+	}
+	match, needsRehash, err := checkPassword(ctx, hash, password)
+	if err != nil {
+		logger.WarnContext(ctx, "Login failed - password hashing busy", "username", username, "client_ip", c.ClientIP(), "error", err.Error())
+		loginAttemptsTotal.WithLabelValues("busy").Inc()
+		audit(c, AuditLoginFailed, username, "busy")
+		respondHashingBusy(c)
+		return
+	}
+	if !exists || !match {
+		logger.WarnContext(ctx, "Login failed", "username", username, "client_ip", c.ClientIP())
+		loginAttemptsTotal.WithLabelValues("failure").Inc()
+		redirectWithError(c, "/login", "Invalid username or password", username)
+		audit(c, AuditLoginFailed, username, "bad_credentials")
+		return
+	}
+	// From here on use the name as registered, whatever case was typed
+	username = user.Username
+
+	if user.Locked {
+		logger.WarnContext(ctx, "Login failed - account locked", "username", username, "client_ip", c.ClientIP())
+		loginAttemptsTotal.WithLabelValues("locked").Inc()
+		redirectWithError(c, "/login", "This account is locked, please contact an administrator", username)
+		audit(c, AuditLoginFailed, username, "locked")
 		return
 	}
 
-	sessionToken := generateToken(32)
-	csrfToken := generateToken(32)
+	if needsRehash {
+		rehashPassword(ctx, username, user.PasswordHash, password)
+	}
 
-	usersMutex.Lock()
-	user.SessionToken = sessionToken
-	user.CSRFToken = csrfToken
-	usersMutex.Unlock()
+	sessionToken := generateToken(config.Session.TokenLength)
+	csrfToken := generateToken(config.Session.TokenLength)
+
+	startSession(ctx, username, sessionToken, csrfToken)
 
 	// Set session cookies with secure flag for HTTPS
-	c.SetCookie("session_token", sessionToken, 3600*24, "/", "", true, true)
-	c.SetCookie("csrf_token", csrfToken, 3600*24, "/", "", true, false)
+	c.SetCookie("session_token", sessionToken, int(config.Session.CookieMaxAge.Seconds()), "/", "", true, true)
+	c.SetCookie("csrf_token", csrfToken, int(config.Session.CookieMaxAge.Seconds()), "/", "", true, false)
 
-	logger.Info("User logged in successfully", "username", username, "client_ip", c.ClientIP())
+	setLogUser(c, username)
+	logger.InfoContext(ctx, "User logged in successfully", "username", username, "client_ip", c.ClientIP())
+	loginAttemptsTotal.WithLabelValues("success").Inc()
+	audit(c, AuditLogin, username, "")
 	c.Redirect(http.StatusSeeOther, "/protected-page")
 }
 
 func logoutUser(c *gin.Context) {
+	ctx := c.Request.Context()
 	sessionToken, err := c.Cookie("session_token")
 	if err != nil {
 		c.Redirect(http.StatusSeeOther, "/")
@@ -202,51 +357,152 @@ func logoutUser(c *gin.Context) {
 	// Validate CSRF token
 	submittedCSRFToken := c.PostForm("csrf_token")
 	if submittedCSRFToken == "" {
-		logger.Warn("Logout failed - missing CSRF token", "client_ip", c.ClientIP())
+		logger.WarnContext(ctx, "Logout failed - missing CSRF token", "client_ip", c.ClientIP())
+		audit(c, AuditCSRFFailed, "", "missing_token")
 		c.String(http.StatusBadRequest, "Missing CSRF token")
 		return
 	}
 
 	// Find user and validate CSRF token
-	usersMutex.Lock()
-	var username string
-	var currentUser *User
-	for _, user := range users {
-		if user.SessionToken == sessionToken {
-			currentUser = user
-			username = user.Username
-			break
-		}
-	}
-	
-	if currentUser == nil {
-		usersMutex.Unlock()
-		logger.Warn("Logout failed - invalid session", "client_ip", c.ClientIP())
+	currentUser, exists := findUserBySession(ctx, sessionToken)
+	if !exists {
+		logger.WarnContext(ctx, "Logout failed - invalid session", "client_ip", c.ClientIP())
 		c.Redirect(http.StatusSeeOther, "/")
 		return
 	}
-	
+	username := currentUser.Username
+	setLogUser(c, username)
+
 	// Validate CSRF token matches user's stored token
-	if currentUser.CSRFToken != submittedCSRFToken {
-		usersMutex.Unlock()
-		logger.Warn("Logout failed - invalid CSRF token", "username", username, "client_ip", c.ClientIP())
+	if !tokensEqual(submittedCSRFToken, currentUser.CSRFToken) {
+		logger.WarnContext(ctx, "Logout failed - invalid CSRF token", "username", username, "client_ip", c.ClientIP())
+		audit(c, AuditCSRFFailed, username, "token_mismatch")
 		c.String(http.StatusForbidden, "Invalid CSRF token")
 		return
 	}
-	
+
 	// Clear session and CSRF token
-	currentUser.SessionToken = ""
-	currentUser.CSRFToken = ""
-	usersMutex.Unlock()
+	endSession(ctx, username)
 
 	// Clear cookies with secure flag for HTTPS
 	c.SetCookie("session_token", "", -1, "/", "", true, true)
 	c.SetCookie("csrf_token", "", -1, "/", "", true, false)
 
-	logger.Info("User logged out", "username", username, "client_ip", c.ClientIP())
+	logger.InfoContext(ctx, "User logged out", "username", username, "client_ip", c.ClientIP())
+	audit(c, AuditLogout, username, "")
 	c.Redirect(http.StatusSeeOther, "/")
 }
 
+// changePassword replaces the signed-in user's password. The session is
+// rotated afterwards so any other copy of the old cookie stops working.
+func changePassword(c *gin.Context) {
+	ctx := c.Request.Context()
+	currentUser, err := getCurrentUser(c)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+	username := currentUser.Username
+
+	if !tokensEqual(c.PostForm("csrf_token"), currentUser.CSRFToken) {
+		logger.WarnContext(ctx, "Password change failed - invalid CSRF token", "username", username, "client_ip", c.ClientIP())
+		audit(c, AuditCSRFFailed, username, "token_mismatch")
+		c.String(http.StatusForbidden, "Invalid CSRF token")
+		return
+	}
+
+	currentPassword := c.PostForm("current_password")
+	newPassword := c.PostForm("new_password")
+	match, _, err := checkPassword(ctx, currentUser.PasswordHash, currentPassword)
+	if err != nil {
+		logger.WarnContext(ctx, "Password change failed - password hashing busy", "username", username, "client_ip", c.ClientIP(), "error", err.Error())
+		respondHashingBusy(c)
+		return
+	}
+	if !match {
+		logger.WarnContext(ctx, "Password change failed - wrong current password", "username", username, "client_ip", c.ClientIP())
+		redirectWithError(c, "/change-password", "Current password is incorrect", "")
+		audit(c, AuditPasswordChangeFailed, username, "bad_credentials")
+		return
+	}
+	if newPassword != c.PostForm("confirm_password") {
+		redirectWithError(c, "/change-password", "New passwords don't match", "")
+		return
+	}
+	if newPassword == currentPassword {
+		redirectWithError(c, "/change-password", "New password must be different from the current one", "")
+		return
+	}
+	err = config.Policy.Password.Validate(username, newPassword)
+	if err == nil {
+		err = screenPassword(ctx, username, newPassword)
+	}
+	if hashingRefused(err) {
+		logger.WarnContext(ctx, "Password change failed - password hashing busy", "username", username, "client_ip", c.ClientIP(), "error", err.Error())
+		respondHashingBusy(c)
+		return
+	}
+	if err != nil {
+		redirectWithError(c, "/change-password", "Invalid password: "+err.Error(), "")
+		audit(c, AuditPasswordChangeFailed, username, passwordRejectReason(err))
+		return
+	}
+
+	hashedPassword, err := hashPassword(ctx, newPassword)
+	if hashingRefused(err) {
+		logger.WarnContext(ctx, "Password change failed - password hashing busy", "username", username, "client_ip", c.ClientIP(), "error", err.Error())
+		respondHashingBusy(c)
+		return
+	}
+	if errors.Is(err, passhash.ErrPasswordTooLong) {
+		redirectWithError(c, "/change-password", "Invalid password: password must be no more than 72 bytes", "")
+		return
+	}
+	if err != nil {
+		logger.ErrorContext(ctx, "Password change failed - password hashing error", "username", username, "error", err.Error())
+		redirectWithError(c, "/change-password", "Something went wrong, please try again", "")
+		return
+	}
+	err = updateUser(ctx, username, func(u *User) {
+		u.PasswordHash = hashedPassword
+		u.MustChangePassword = false
+	})
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/login")
+		return
+	}
+
+	sessionToken := generateToken(config.Session.TokenLength)
+	csrfToken := generateToken(config.Session.TokenLength)
+	startSession(ctx, username, sessionToken, csrfToken)
+	c.SetCookie("session_token", sessionToken, int(config.Session.CookieMaxAge.Seconds()), "/", "", true, true)
+	c.SetCookie("csrf_token", csrfToken, int(config.Session.CookieMaxAge.Seconds()), "/", "", true, false)
+
+	logger.InfoContext(ctx, "Password changed", "username", username, "client_ip", c.ClientIP())
+	audit(c, AuditPasswordChanged, username, "")
+	c.Redirect(http.StatusSeeOther, "/protected-page")
+}
+
+// expireSessions clears sessions whose cookie lifetime has passed, so a
+// stolen token stops working server-side too
+func expireSessions() {
+	now := time.Now()
+	expired := 0
+
+	usersMutex.Lock()
+	for _, user := range users {
+		if user.SessionToken != "" && now.After(user.SessionExpiresAt) {
+			user.clearSession()
+			expired++
+		}
+	}
+	usersMutex.Unlock()
+
+	if expired > 0 {
+		logger.Info("Expired sessions cleared", "count", expired)
+	}
+}
+
 func requireAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		sessionToken, err := c.Cookie("session_token")
@@ -256,23 +512,22 @@ func requireAuth() gin.HandlerFunc {
 			return
 		}
 
-		usersMutex.RLock()
-		var currentUser *User
-		for _, user := range users {
-			if user.SessionToken == sessionToken && sessionToken != "" {
-				currentUser = user
-				break
-			}
-		}
-		usersMutex.RUnlock()
-
-		if currentUser == nil {
+		currentUser, exists := findUserBySession(c.Request.Context(), sessionToken)
+		if !exists || currentUser.Locked {
 			c.Redirect(http.StatusSeeOther, "/login")
 			c.Abort()
 			return
 		}
 
 		c.Set("user", currentUser)
+		setLogUser(c, currentUser.Username)
+
+		// An admin forced a password reset; nothing else is reachable until it's done
+		if currentUser.MustChangePassword && c.FullPath() != "/change-password" {
+			c.Redirect(http.StatusSeeOther, "/change-password")
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
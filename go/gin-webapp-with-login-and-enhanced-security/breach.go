@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The corpus uses the Have I Been Pwned range format: one file per 5 hex
+// character prefix of the password's SHA-1, named like 21BD1.txt, holding
+// lines of "<remaining 35 hex characters>:<times seen>". Lookups only ever
+// open the file for one prefix, so the full corpus can stay on disk.
+const breachPrefixLength = 5
+
+// breachCount returns how often password appears in the configured corpus.
+// A missing prefix file means no password with that prefix was breached.
+func breachCount(ctx context.Context, password string) (int, error) {
+	_, span := tracer.Start(ctx, "password.breach_lookup")
+	defer span.End()
+
+	sum := sha1.Sum([]byte(password))
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := digest[:breachPrefixLength], digest[breachPrefixLength:]
+
+	file, err := os.Open(filepath.Join(config.Password.BreachCorpus, prefix+".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		hashSuffix, count, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !ok || !strings.EqualFold(hashSuffix, suffix) {
+			continue
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return 0, errors.New("malformed breach corpus line in " + file.Name())
+		}
+		return n, nil
+	}
+	return 0, scanner.Err()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// breachDigest splits password's SHA-1 into its range file prefix and the
+// suffix listed in that file
+func breachDigest(password string) (prefix, suffix string) {
+	sum := sha1.Sum([]byte(password))
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+	return digest[:breachPrefixLength], digest[breachPrefixLength:]
+}
+
+func writeRangeFile(t *testing.T, prefix string, lines ...string) {
+	t.Helper()
+	file := filepath.Join(config.Password.BreachCorpus, prefix+".txt")
+	if err := os.WriteFile(file, []byte(strings.Join(lines, "\r\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBreachCount(t *testing.T) {
+	config = defaultConfig()
+	config.Password.BreachCorpus = t.TempDir()
+	ctx := context.Background()
+
+	prefix, suffix := breachDigest("Password123")
+	other := "0000000000000000000000000000000000A"
+	writeRangeFile(t, prefix, other+":7", strings.ToLower(suffix)+":251682")
+
+	if count, err := breachCount(ctx, "Password123"); err != nil || count != 251682 {
+		t.Errorf("listed password: got %d, %v", count, err)
+	}
+
+	// Same prefix, different suffix
+	writeRangeFile(t, prefix, other+":7")
+	if count, err := breachCount(ctx, "Password123"); err != nil || count != 0 {
+		t.Errorf("unlisted suffix: got %d, %v", count, err)
+	}
+
+	// No file at all for the prefix
+	if count, err := breachCount(ctx, "quiet-lantern-orbit-47"); err != nil || count != 0 {
+		t.Errorf("missing prefix file: got %d, %v", count, err)
+	}
+}
+
+func TestBreachCountMalformedLines(t *testing.T) {
+	config = defaultConfig()
+	config.Password.BreachCorpus = t.TempDir()
+	ctx := context.Background()
+
+	// Lines without a count are skipped
+	prefix, suffix := breachDigest("Password123")
+	writeRangeFile(t, prefix, "garbage", suffix+":12")
+	if count, err := breachCount(ctx, "Password123"); err != nil || count != 12 {
+		t.Errorf("after a line without a separator: got %d, %v", count, err)
+	}
+
+	// A matching line with a bad count is an error rather than a pass
+	writeRangeFile(t, prefix, suffix+":lots")
+	if _, err := breachCount(ctx, "Password123"); err == nil {
+		t.Error("a malformed count for the password was not reported")
+	}
+}
+
+// A corpus that can't be read skips the check instead of blocking sign-ups
+func TestScreenPasswordSkipsUnreadableCorpus(t *testing.T) {
+	logger = slog.New(slog.DiscardHandler)
+	config = defaultConfig()
+	config.Password.BreachCorpus = t.TempDir()
+	prefix, suffix := breachDigest("quiet-lantern-orbit-47")
+	writeRangeFile(t, prefix, suffix+":lots")
+
+	if err := screenPassword(context.Background(), "alice", "quiet-lantern-orbit-47"); err != nil {
+		t.Errorf("got %v, want the breach check skipped", err)
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"shared/cli"
+	"shared/server"
+)
+
+// commands are maintenance tasks run as `go run . <name> [flags]` instead of
+// starting the server
+var commands = cli.Commands{
+	"create-admin": {Summary: "create the first admin, or promote a user, in the state file", Run: runCreateAdmin},
+	"devcert":      {Summary: "write a self-signed certificate for local HTTPS testing", Run: server.RunDevCert},
+	"verify-audit": {Summary: "check the audit log hash chain for tampering", Run: runVerifyAudit},
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"shared/authpolicy"
+	"shared/passhash"
+	"shared/settings"
+)
+
+// Config holds every tunable setting of the server. Values are resolved in
+// order of precedence: defaults, YAML file, environment variables, CLI flags.
+type Config struct {
+	Server   ServerConfig      `yaml:"server"`
+	Session  SessionConfig     `yaml:"session"`
+	Password PasswordConfig    `yaml:"password"`
+	Policy   authpolicy.Config `yaml:"policy"`
+	Security SecurityConfig    `yaml:"security"`
+	Store    StoreConfig       `yaml:"store"`
+	TLS      TLSConfig         `yaml:"tls"`
+	Metrics  MetricsConfig     `yaml:"metrics"`
+	Tracing  TracingConfig     `yaml:"tracing"`
+	Audit    AuditConfig       `yaml:"audit"`
+	Logging  LoggingConfig     `yaml:"logging"`
+}
+
+type ServerConfig struct {
+	Addr            string        `yaml:"addr"`
+	GinMode         string        `yaml:"gin_mode"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	DrainDelay      time.Duration `yaml:"drain_delay"`
+}
+
+type SessionConfig struct {
+	CookieMaxAge time.Duration `yaml:"cookie_max_age"`
+	TokenLength  int           `yaml:"token_length"`
+}
+
+// TLSConfig enables HTTPS when both files are set. The pair is reloaded
+// whenever either file changes. RedirectAddr optionally starts a plain HTTP
+// listener that redirects everything to the TLS listener on Server.Addr.
+type TLSConfig struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	RedirectAddr string `yaml:"redirect_addr"`
+}
+
+// Enabled reports whether the server should serve HTTPS
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != ""
+}
+
+// MetricsConfig protects /metrics. Scrapers must send Token as a bearer
+// token; when it is empty only loopback clients may scrape.
+type MetricsConfig struct {
+	Token string `yaml:"token"`
+}
+
+// TracingConfig selects where OpenTelemetry spans are sent: "none",
+// "stdout", or "otlp" (OTLP over HTTP, e.g. a local collector on :4318)
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter"`
+	Endpoint    string  `yaml:"endpoint"`
+	Insecure    bool    `yaml:"insecure"`
+	ServiceName string  `yaml:"service_name"`
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+type LoggingConfig struct {
+	Redact RedactConfig `yaml:"redact"`
+}
+
+// RedactConfig lists log attribute keys to pseudonymise with an HMAC, to
+// truncate to a network prefix, or to drop. HashKey keeps pseudonyms stable
+// across restarts; without it a random key is used per run.
+type RedactConfig struct {
+	HashKey    string   `yaml:"hash_key"`
+	Hash       []string `yaml:"hash"`
+	TruncateIP []string `yaml:"truncate_ip"`
+	Drop       []string `yaml:"drop"`
+}
+
+// AuditConfig controls the security audit log. An empty File disables it.
+// AdminToken is the bearer token for the admin query endpoint, which is
+// disabled while it is empty.
+type AuditConfig struct {
+	File       string `yaml:"file"`
+	MaxSizeMB  int    `yaml:"max_size_mb"`
+	AdminToken string `yaml:"admin_token"`
+}
+
+// StoreConfig controls persistence of the in-memory user store. With no
+// state file, users and sessions are lost when the process exits.
+type StoreConfig struct {
+	StateFile string `yaml:"state_file"`
+}
+
+// PasswordConfig picks the algorithm for new password hashes, and how new
+// passwords are screened. Stored hashes of either algorithm still verify and
+// are upgraded on the next login.
+type PasswordConfig struct {
+	passhash.Config `yaml:",inline"`
+	// BreachCorpus is a directory of HIBP range files; empty disables the check
+	BreachCorpus   string `yaml:"breach_corpus"`
+	BreachMinCount int    `yaml:"breach_min_count"`
+	// MinStrength is the lowest accepted estimator score, 0 (off) to 4
+	MinStrength int `yaml:"min_strength"`
+}
+
+// config is the active configuration, set once at startup
+var config = defaultConfig()
+
+func defaultConfig() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:            ":8080",
+			GinMode:         gin.ReleaseMode,
+			ShutdownTimeout: 25 * time.Second,
+			// Long enough for two failed readiness checks at Fly's 5s interval
+			DrainDelay: 10 * time.Second,
+		},
+		Session: SessionConfig{
+			CookieMaxAge: 24 * time.Hour,
+			TokenLength:  32,
+		},
+		Password: PasswordConfig{
+			Config:         passhash.DefaultConfig(),
+			BreachMinCount: 1,
+			MinStrength:    3,
+		},
+		Policy: authpolicy.Config{
+			Username: authpolicy.UsernamePolicy{
+				MinLength:        3,
+				MaxLength:        50,
+				Pattern:          `[a-zA-Z0-9_-]+`,
+				PatternHint:      "letters, numbers, underscores, and hyphens",
+				Normalize:        "nfkc",
+				Reserved:         []string{"admin", "administrator", "root", "system", "support", "security", "webmaster"},
+				RejectConfusable: true,
+			},
+			Password: authpolicy.PasswordPolicy{
+				MinLength:      8,
+				MaxLength:      128,
+				Require:        []string{"upper", "lower", "digit"},
+				Normalize:      "nfkc",
+				RejectUsername: true,
+			},
+		},
+		Security: defaultSecurityConfig(),
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			ServiceName: "gin-webapp-with-login-and-enhanced-security",
+			SampleRatio: 1,
+		},
+		Audit: AuditConfig{
+			File:      "audit.log",
+			MaxSizeMB: 10,
+		},
+		Logging: LoggingConfig{
+			Redact: RedactConfig{
+				Hash:       []string{"username", "user", "actor"},
+				TruncateIP: []string{"client_ip"},
+				Drop:       []string{"user_agent"},
+			},
+		},
+	}
+}
+
+func (cfg *Config) settings() []settings.Setting {
+	return []settings.Setting{
+		settings.String("APP_ADDR", "addr", "listen address", &cfg.Server.Addr),
+		settings.String("APP_GIN_MODE", "gin-mode", "gin mode (debug, release or test)", &cfg.Server.GinMode),
+		settings.Duration("APP_SHUTDOWN_TIMEOUT", "shutdown-timeout", "how long shutdown may take, draining and hooks included", &cfg.Server.ShutdownTimeout),
+		settings.Duration("APP_DRAIN_DELAY", "drain-delay", "how long /readyz fails before listeners close on shutdown", &cfg.Server.DrainDelay),
+		settings.String("APP_STATE_FILE", "state-file", "file users and sessions are saved to on shutdown", &cfg.Store.StateFile),
+		settings.Duration("APP_SESSION_MAX_AGE", "session-max-age", "lifetime of session cookies", &cfg.Session.CookieMaxAge),
+		settings.String("APP_TLS_CERT", "tls-cert", "TLS certificate file (enables HTTPS)", &cfg.TLS.CertFile),
+		settings.String("APP_TLS_KEY", "tls-key", "TLS private key file", &cfg.TLS.KeyFile),
+		settings.String("APP_TLS_REDIRECT_ADDR", "tls-redirect-addr", "plain HTTP address that redirects to HTTPS", &cfg.TLS.RedirectAddr),
+		settings.String("APP_METRICS_TOKEN", "metrics-token", "bearer token required to scrape /metrics", &cfg.Metrics.Token),
+		settings.String("APP_TRACING_EXPORTER", "tracing-exporter", "trace exporter (none, stdout or otlp)", &cfg.Tracing.Exporter),
+		settings.String("APP_TRACING_ENDPOINT", "tracing-endpoint", "OTLP/HTTP collector host:port", &cfg.Tracing.Endpoint),
+		settings.Bool("APP_TRACING_INSECURE", "tracing-insecure", "send OTLP over plain HTTP", &cfg.Tracing.Insecure),
+		settings.Float("APP_TRACING_SAMPLE_RATIO", "tracing-sample-ratio", "fraction of new traces to sample", &cfg.Tracing.SampleRatio),
+		settings.String("APP_AUDIT_FILE", "audit-file", "security audit log file (empty disables)", &cfg.Audit.File),
+		settings.Int("APP_AUDIT_MAX_SIZE_MB", "audit-max-size-mb", "size at which the audit log is rotated", &cfg.Audit.MaxSizeMB),
+		settings.String("APP_ADMIN_TOKEN", "admin-token", "bearer token for the admin endpoints", &cfg.Audit.AdminToken),
+		settings.String("APP_LOG_HASH_KEY", "log-hash-key", "HMAC key for pseudonymised log fields", &cfg.Logging.Redact.HashKey),
+		settings.List("APP_LOG_HASH_FIELDS", "log-hash-fields", "comma separated log fields to pseudonymise", &cfg.Logging.Redact.Hash),
+		settings.List("APP_LOG_TRUNCATE_IP_FIELDS", "log-truncate-ip-fields", "comma separated log fields holding IPs to truncate", &cfg.Logging.Redact.TruncateIP),
+		settings.List("APP_LOG_DROP_FIELDS", "log-drop-fields", "comma separated log fields to leave out", &cfg.Logging.Redact.Drop),
+		settings.Int("APP_TOKEN_LENGTH", "token-length", "random bytes in session and CSRF tokens", &cfg.Session.TokenLength),
+		settings.String("APP_PASSWORD_ALGORITHM", "password-algorithm", "hash algorithm for new passwords (argon2id or bcrypt)", &cfg.Password.Algorithm),
+		settings.Int("APP_BCRYPT_COST", "bcrypt-cost", "bcrypt work factor", &cfg.Password.BcryptCost),
+		settings.Int("APP_ARGON2_MEMORY_KIB", "argon2-memory-kib", "argon2id memory in KiB", &cfg.Password.Argon2.MemoryKiB),
+		settings.Int("APP_ARGON2_ITERATIONS", "argon2-iterations", "argon2id passes over memory", &cfg.Password.Argon2.Iterations),
+		settings.Int("APP_ARGON2_PARALLELISM", "argon2-parallelism", "argon2id threads", &cfg.Password.Argon2.Parallelism),
+		settings.Int("APP_HASH_WORKERS", "hash-workers", "password hashes computed at once", &cfg.Password.Pool.Workers),
+		settings.Int("APP_HASH_QUEUE", "hash-queue", "requests that may wait for a hashing worker", &cfg.Password.Pool.Queue),
+		settings.Duration("APP_HASH_QUEUE_TIMEOUT", "hash-queue-timeout", "longest wait for a hashing worker before answering 503", &cfg.Password.Pool.QueueTimeout),
+		settings.Duration("APP_HASH_RETRY_AFTER", "hash-retry-after", "Retry-After sent with 503 when hashing is saturated", &cfg.Password.Pool.RetryAfter),
+		settings.String("APP_BREACH_CORPUS", "breach-corpus", "directory of breached password range files (empty disables)", &cfg.Password.BreachCorpus),
+		settings.Int("APP_BREACH_MIN_COUNT", "breach-min-count", "breach count at which a password is refused", &cfg.Password.BreachMinCount),
+		settings.Int("APP_PASSWORD_MIN_STRENGTH", "password-min-strength", "lowest accepted password strength score (0-4)", &cfg.Password.MinStrength),
+		settings.Int("APP_USERNAME_MIN_LENGTH", "username-min-length", "shortest username accepted at registration", &cfg.Policy.Username.MinLength),
+		settings.Int("APP_PASSWORD_MIN_LENGTH", "password-min-length", "shortest password accepted", &cfg.Policy.Password.MinLength),
+		settings.String("APP_PASSWORD_DENY_FILE", "password-deny-file", "file of refused passwords, one per line", &cfg.Policy.Password.DenyFile),
+		settings.List("APP_RESERVED_USERNAMES", "reserved-usernames", "comma separated usernames that can't be registered", &cfg.Policy.Username.Reserved),
+		settings.Bool("SECURITY_DEV_MODE", "dev", "development mode (no HSTS)", &cfg.Security.DevMode),
+		settings.String("SECURITY_HSTS", "hsts", "Strict-Transport-Security header", &cfg.Security.Default.StrictTransportSecurity),
+		settings.String("SECURITY_PERMISSIONS_POLICY", "permissions-policy", "Permissions-Policy header", &cfg.Security.Default.PermissionsPolicy),
+		settings.String("SECURITY_REFERRER_POLICY", "referrer-policy", "Referrer-Policy header", &cfg.Security.Default.ReferrerPolicy),
+		settings.String("SECURITY_COOP", "coop", "Cross-Origin-Opener-Policy header", &cfg.Security.Default.CrossOriginOpenerPolicy),
+		settings.String("SECURITY_COEP", "coep", "Cross-Origin-Embedder-Policy header", &cfg.Security.Default.CrossOriginEmbedderPolicy),
+		settings.String("SECURITY_CORP", "corp", "Cross-Origin-Resource-Policy header", &cfg.Security.Default.CrossOriginResourcePolicy),
+	}
+}
+
+// loadConfig builds the configuration from defaults, the YAML file given by
+// -config or APP_CONFIG, environment variables and finally CLI flags
+func loadConfig(args []string) (*Config, error) {
+	cfg := defaultConfig()
+	if err := settings.Load(args, cfg, cfg.settings()); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// validate reports every invalid setting at once
+func (cfg *Config) validate() error {
+	var errs []error
+
+	if _, _, err := net.SplitHostPort(cfg.Server.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("server.addr %q must be host:port or :port", cfg.Server.Addr))
+	}
+	switch cfg.Server.GinMode {
+	case gin.DebugMode, gin.ReleaseMode, gin.TestMode:
+	default:
+		errs = append(errs, fmt.Errorf("server.gin_mode %q must be debug, release or test", cfg.Server.GinMode))
+	}
+	if cfg.Server.ShutdownTimeout < time.Second {
+		errs = append(errs, fmt.Errorf("server.shutdown_timeout %s must be at least 1s", cfg.Server.ShutdownTimeout))
+	}
+	// The delay comes out of shutdown_timeout, so leave most of it for draining
+	if cfg.Server.DrainDelay < 0 || cfg.Server.DrainDelay >= cfg.Server.ShutdownTimeout/2 {
+		errs = append(errs, fmt.Errorf("server.drain_delay %s must be between 0 and half of server.shutdown_timeout", cfg.Server.DrainDelay))
+	}
+	if cfg.TLS.Enabled() && (cfg.TLS.CertFile == "" || cfg.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("tls.cert_file and tls.key_file must be set together"))
+	}
+	if cfg.TLS.RedirectAddr != "" {
+		if !cfg.TLS.Enabled() {
+			errs = append(errs, errors.New("tls.redirect_addr requires tls.cert_file and tls.key_file"))
+		} else if _, _, err := net.SplitHostPort(cfg.TLS.RedirectAddr); err != nil {
+			errs = append(errs, fmt.Errorf("tls.redirect_addr %q must be host:port or :port", cfg.TLS.RedirectAddr))
+		}
+	}
+
+	if cfg.Session.CookieMaxAge < time.Minute {
+		errs = append(errs, fmt.Errorf("session.cookie_max_age %s must be at least 1m", cfg.Session.CookieMaxAge))
+	}
+	if cfg.Session.TokenLength < 16 || cfg.Session.TokenLength > 256 {
+		errs = append(errs, fmt.Errorf("session.token_length %d must be between 16 and 256", cfg.Session.TokenLength))
+	}
+
+	if err := cfg.Password.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if cfg.Password.BreachCorpus != "" {
+		if info, err := os.Stat(cfg.Password.BreachCorpus); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Errorf("password.breach_corpus %q must be a directory", cfg.Password.BreachCorpus))
+		}
+	}
+	if cfg.Password.BreachMinCount < 1 {
+		errs = append(errs, fmt.Errorf("password.breach_min_count %d must be at least 1", cfg.Password.BreachMinCount))
+	}
+	if cfg.Password.MinStrength < 0 || cfg.Password.MinStrength > 4 {
+		errs = append(errs, fmt.Errorf("password.min_strength %d must be between 0 and 4", cfg.Password.MinStrength))
+	}
+	// Also loads the deny file, so a missing one is reported with the rest
+	if err := cfg.Policy.Compile(); err != nil {
+		errs = append(errs, err)
+	}
+
+	switch cfg.Tracing.Exporter {
+	case "none", "stdout", "otlp":
+	default:
+		errs = append(errs, fmt.Errorf("tracing.exporter %q must be none, stdout or otlp", cfg.Tracing.Exporter))
+	}
+	if cfg.Tracing.SampleRatio < 0 || cfg.Tracing.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("tracing.sample_ratio %g must be between 0 and 1", cfg.Tracing.SampleRatio))
+	}
+	if cfg.Tracing.ServiceName == "" {
+		errs = append(errs, errors.New("tracing.service_name must not be empty"))
+	}
+
+	if cfg.Audit.MaxSizeMB < 1 {
+		errs = append(errs, fmt.Errorf("audit.max_size_mb %d must be at least 1", cfg.Audit.MaxSizeMB))
+	}
+
+	if key := cfg.Logging.Redact.HashKey; key != "" && len(key) < 16 {
+		errs = append(errs, errors.New("logging.redact.hash_key must be at least 16 characters"))
+	}
+
+	for group := range cfg.Security.Routes {
+		if !strings.HasPrefix(group, "/") {
+			errs = append(errs, fmt.Errorf("security.routes key %q must be a path starting with /", group))
+		}
+	}
+
+	return errors.Join(errs...)
+}
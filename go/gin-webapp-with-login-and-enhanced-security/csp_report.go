@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	cspReportPath     = "/csp-report"
+	cspReportGroup    = "csp-endpoint"
+	maxCSPReportBytes = 64 << 10
+	maxCSPFieldLength = 512
+	cspDedupeWindow   = 10 * time.Minute
+	maxCSPDedupeKeys  = 10000
+)
+
+// cspViolation is the normalised form of both report formats
+type cspViolation struct {
+	DocumentURL        string
+	BlockedURL         string
+	EffectiveDirective string
+	Disposition        string
+	SourceFile         string
+	LineNumber         int
+	ColumnNumber       int
+	Sample             string
+}
+
+// Legacy report-uri payload sent as application/csp-report
+type legacyCSPReport struct {
+	Report struct {
+		DocumentURI        string `json:"document-uri"`
+		BlockedURI         string `json:"blocked-uri"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		Disposition        string `json:"disposition"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+		ColumnNumber       int    `json:"column-number"`
+		ScriptSample       string `json:"script-sample"`
+	} `json:"csp-report"`
+}
+
+// Reporting API payload sent as application/reports+json
+type reportingAPIReport struct {
+	Type string `json:"type"`
+	Body struct {
+		DocumentURL        string `json:"documentURL"`
+		BlockedURL         string `json:"blockedURL"`
+		EffectiveDirective string `json:"effectiveDirective"`
+		Disposition        string `json:"disposition"`
+		SourceFile         string `json:"sourceFile"`
+		LineNumber         int    `json:"lineNumber"`
+		ColumnNumber       int    `json:"columnNumber"`
+		Sample             string `json:"sample"`
+	} `json:"body"`
+}
+
+var errInvalidCSPReport = errors.New("invalid CSP report")
+
+// Recently seen violations, so a page load that trips the same directive
+// many times (or many visitors hitting the same bug) logs it only once.
+// seenCSPOrder lists keys oldest first, so the map can be kept under its cap
+// by evicting the oldest entry instead of dropping new violations unlogged.
+var (
+	seenCSPReports      = make(map[string]time.Time)
+	seenCSPOrder        []seenCSPReport
+	seenCSPReportsMutex sync.Mutex
+)
+
+type seenCSPReport struct {
+	key string
+	at  time.Time
+}
+
+func truncateField(value string) string {
+	if len(value) > maxCSPFieldLength {
+		return value[:maxCSPFieldLength]
+	}
+	return value
+}
+
+func (v *cspViolation) normalise() error {
+	if v.DocumentURL == "" || v.EffectiveDirective == "" {
+		return errInvalidCSPReport
+	}
+	v.DocumentURL = truncateField(v.DocumentURL)
+	v.BlockedURL = truncateField(v.BlockedURL)
+	v.EffectiveDirective = truncateField(v.EffectiveDirective)
+	v.Disposition = truncateField(v.Disposition)
+	v.SourceFile = truncateField(v.SourceFile)
+	v.Sample = truncateField(v.Sample)
+	return nil
+}
+
+func (v *cspViolation) key() string {
+	return v.DocumentURL + "|" + v.BlockedURL + "|" + v.EffectiveDirective + "|" +
+		v.SourceFile + ":" + strconv.Itoa(v.LineNumber) + ":" + strconv.Itoa(v.ColumnNumber)
+}
+
+// firstSeen reports whether the violation hasn't been logged within the
+// dedupe window. When the map is full the oldest entry makes room, so a
+// flood of junk reports can cause repeats in the log but never hide a new
+// violation.
+func firstSeen(v *cspViolation) bool {
+	now := time.Now()
+	key := v.key()
+
+	seenCSPReportsMutex.Lock()
+	defer seenCSPReportsMutex.Unlock()
+
+	if seen, ok := seenCSPReports[key]; ok && now.Sub(seen) < cspDedupeWindow {
+		return false
+	}
+
+	for len(seenCSPOrder) > 0 {
+		oldest := seenCSPOrder[0]
+		if now.Sub(oldest.at) < cspDedupeWindow && len(seenCSPReports) < maxCSPDedupeKeys {
+			break
+		}
+		// A key seen again after its window has a newer entry further on
+		if seenCSPReports[oldest.key].Equal(oldest.at) {
+			delete(seenCSPReports, oldest.key)
+		}
+		seenCSPOrder = seenCSPOrder[1:]
+	}
+
+	seenCSPReports[key] = now
+	seenCSPOrder = append(seenCSPOrder, seenCSPReport{key: key, at: now})
+	return true
+}
+
+func parseCSPReports(contentType string, body []byte) ([]cspViolation, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, errInvalidCSPReport
+	}
+
+	var violations []cspViolation
+	switch mediaType {
+	case "application/csp-report":
+		var report legacyCSPReport
+		if err := json.Unmarshal(body, &report); err != nil {
+			return nil, errInvalidCSPReport
+		}
+		r := report.Report
+		directive := r.EffectiveDirective
+		if directive == "" {
+			directive = r.ViolatedDirective
+		}
+		violations = append(violations, cspViolation{
+			DocumentURL:        r.DocumentURI,
+			BlockedURL:         r.BlockedURI,
+			EffectiveDirective: directive,
+			Disposition:        r.Disposition,
+			SourceFile:         r.SourceFile,
+			LineNumber:         r.LineNumber,
+			ColumnNumber:       r.ColumnNumber,
+			Sample:             r.ScriptSample,
+		})
+
+	case "application/reports+json":
+		var reports []reportingAPIReport
+		if err := json.Unmarshal(body, &reports); err != nil {
+			return nil, errInvalidCSPReport
+		}
+		for _, r := range reports {
+			// The endpoint may receive other report types; only CSP is handled here
+			if r.Type != "csp-violation" {
+				continue
+			}
+			violations = append(violations, cspViolation{
+				DocumentURL:        r.Body.DocumentURL,
+				BlockedURL:         r.Body.BlockedURL,
+				EffectiveDirective: r.Body.EffectiveDirective,
+				Disposition:        r.Body.Disposition,
+				SourceFile:         r.Body.SourceFile,
+				LineNumber:         r.Body.LineNumber,
+				ColumnNumber:       r.Body.ColumnNumber,
+				Sample:             r.Body.Sample,
+			})
+		}
+
+	default:
+		return nil, errInvalidCSPReport
+	}
+
+	for i := range violations {
+		if err := violations[i].normalise(); err != nil {
+			return nil, err
+		}
+	}
+	return violations, nil
+}
+
+// cspReportHandler accepts violation reports sent by browsers
+func cspReportHandler(c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxCSPReportBytes)
+	body, err := c.GetRawData()
+	if err != nil {
+		logger.WarnContext(c.Request.Context(), "CSP report rejected - body too large or unreadable", "client_ip", c.ClientIP())
+		c.Status(http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	violations, err := parseCSPReports(c.ContentType(), body)
+	if err != nil {
+		logger.WarnContext(c.Request.Context(), "CSP report rejected - invalid payload", "content_type", c.ContentType(), "client_ip", c.ClientIP())
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	for i := range violations {
+		v := &violations[i]
+		if !firstSeen(v) {
+			continue
+		}
+		logger.WarnContext(c.Request.Context(), "CSP violation",
+			"document_url", v.DocumentURL,
+			"blocked_url", v.BlockedURL,
+			"effective_directive", v.EffectiveDirective,
+			"disposition", v.Disposition,
+			"source_file", v.SourceFile,
+			"line_number", v.LineNumber,
+			"column_number", v.ColumnNumber,
+			"sample", v.Sample,
+			"client_ip", c.ClientIP(),
+		)
+	}
+
+	c.Status(http.StatusNoContent)
+}
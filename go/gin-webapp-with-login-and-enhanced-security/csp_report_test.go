@@ -0,0 +1,162 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCSPReports(t *testing.T) {
+	long := strings.Repeat("a", maxCSPFieldLength+100)
+	for _, tc := range []struct {
+		name        string
+		contentType string
+		body        string
+		want        []cspViolation
+		wantErr     bool
+	}{
+		{
+			name:        "legacy report",
+			contentType: "application/csp-report",
+			body: `{"csp-report": {"document-uri": "https://example.com/", "blocked-uri": "inline",
+				"violated-directive": "script-src-elem", "disposition": "enforce", "line-number": 3}}`,
+			want: []cspViolation{{DocumentURL: "https://example.com/", BlockedURL: "inline",
+				EffectiveDirective: "script-src-elem", Disposition: "enforce", LineNumber: 3}},
+		},
+		{
+			name:        "legacy report prefers the effective directive",
+			contentType: "application/csp-report; charset=utf-8",
+			body: `{"csp-report": {"document-uri": "https://example.com/",
+				"violated-directive": "script-src", "effective-directive": "script-src-attr"}}`,
+			want: []cspViolation{{DocumentURL: "https://example.com/", EffectiveDirective: "script-src-attr"}},
+		},
+		{
+			name:        "reporting API skips other report types",
+			contentType: "application/reports+json",
+			body: `[{"type": "deprecation", "body": {"id": "x"}},
+				{"type": "csp-violation", "body": {"documentURL": "https://example.com/a",
+					"blockedURL": "https://cdn.example.net/x.js", "effectiveDirective": "script-src-elem",
+					"sourceFile": "https://example.com/app.js", "columnNumber": 7, "sample": "x"}}]`,
+			want: []cspViolation{{DocumentURL: "https://example.com/a", BlockedURL: "https://cdn.example.net/x.js",
+				EffectiveDirective: "script-src-elem", SourceFile: "https://example.com/app.js", ColumnNumber: 7, Sample: "x"}},
+		},
+		{
+			name:        "long fields are truncated",
+			contentType: "application/csp-report",
+			body:        `{"csp-report": {"document-uri": "` + long + `", "effective-directive": "img-src", "script-sample": "` + long + `"}}`,
+			want: []cspViolation{{DocumentURL: long[:maxCSPFieldLength], EffectiveDirective: "img-src",
+				Sample: long[:maxCSPFieldLength]}},
+		},
+		{
+			name:        "missing document uri",
+			contentType: "application/csp-report",
+			body:        `{"csp-report": {"blocked-uri": "inline", "effective-directive": "script-src"}}`,
+			wantErr:     true,
+		},
+		{
+			name:        "missing directive in the reporting API",
+			contentType: "application/reports+json",
+			body:        `[{"type": "csp-violation", "body": {"documentURL": "https://example.com/"}}]`,
+			wantErr:     true,
+		},
+		{
+			name:        "wrong content type",
+			contentType: "application/json",
+			body:        `{"csp-report": {"document-uri": "https://example.com/", "effective-directive": "img-src"}}`,
+			wantErr:     true,
+		},
+		{
+			name:        "malformed JSON",
+			contentType: "application/reports+json",
+			body:        `[{"type": "csp-violation"`,
+			wantErr:     true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseCSPReports(tc.contentType, []byte(tc.body))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("got %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("got %d violations, want %d", len(got), len(tc.want))
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("violation %d is %+v, want %+v", i, got[i], tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func resetSeenCSPReports() {
+	seenCSPReportsMutex.Lock()
+	defer seenCSPReportsMutex.Unlock()
+	seenCSPReports = make(map[string]time.Time)
+	seenCSPOrder = nil
+}
+
+func violationFor(page int) *cspViolation {
+	return &cspViolation{DocumentURL: "https://example.com/" + strconv.Itoa(page), EffectiveDirective: "img-src"}
+}
+
+func TestFirstSeen(t *testing.T) {
+	resetSeenCSPReports()
+	defer resetSeenCSPReports()
+
+	v := violationFor(0)
+	if !firstSeen(v) {
+		t.Fatal("a new violation was not logged")
+	}
+	if firstSeen(v) {
+		t.Error("a repeat within the dedupe window was logged again")
+	}
+	if !firstSeen(violationFor(1)) {
+		t.Error("a different violation was taken for a repeat")
+	}
+
+	// Once the window has passed the violation is logged again
+	seenCSPReportsMutex.Lock()
+	expired := time.Now().Add(-cspDedupeWindow - time.Second)
+	seenCSPReports[v.key()] = expired
+	seenCSPOrder[0].at = expired
+	seenCSPReportsMutex.Unlock()
+	if !firstSeen(v) {
+		t.Error("a violation past its window was not logged again")
+	}
+	if firstSeen(v) {
+		t.Error("a violation logged again was not remembered")
+	}
+}
+
+func TestFirstSeenEvictsOldest(t *testing.T) {
+	resetSeenCSPReports()
+	defer resetSeenCSPReports()
+
+	for page := range maxCSPDedupeKeys {
+		if !firstSeen(violationFor(page)) {
+			t.Fatalf("violation %d was not logged", page)
+		}
+	}
+	// A new violation is still logged when the map is full, pushing out
+	// the oldest one
+	if !firstSeen(violationFor(maxCSPDedupeKeys)) {
+		t.Fatal("a new violation was dropped when the map was full")
+	}
+	if len(seenCSPReports) != maxCSPDedupeKeys {
+		t.Errorf("holding %d keys, want the cap of %d", len(seenCSPReports), maxCSPDedupeKeys)
+	}
+	if firstSeen(violationFor(maxCSPDedupeKeys - 1)) {
+		t.Error("a recent violation was evicted")
+	}
+	if !firstSeen(violationFor(0)) {
+		t.Error("the oldest violation was not evicted")
+	}
+}
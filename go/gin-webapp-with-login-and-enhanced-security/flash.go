@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const flashCookieName = "flash"
+
+// Flash is a one-shot message carried across a redirect. The submitted
+// username is kept so the form can be pre-filled when it is shown again.
+type Flash struct {
+	Kind     string `json:"k"`
+	Message  string `json:"m"`
+	Username string `json:"u,omitempty"`
+}
+
+// flashKey signs flash cookies so clients can't inject their own messages.
+// It is regenerated on every start, which simply invalidates any pending flash.
+var flashKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic("failed to generate flash key: " + err.Error())
+	}
+	return key
+}()
+
+func signFlash(payload string) string {
+	mac := hmac.New(sha256.New, flashKey)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// setFlash stores a signed flash message to be shown on the next page render
+func setFlash(c *gin.Context, kind, message, username string) {
+	data, err := json.Marshal(Flash{Kind: kind, Message: message, Username: username})
+	if err != nil {
+		logger.ErrorContext(c.Request.Context(), "Failed to encode flash message", "error", err.Error())
+		return
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(flashCookieName, payload+"."+signFlash(payload), 60, "/", "", true, true)
+}
+
+// popFlash returns the pending flash message, if any, and clears it
+func popFlash(c *gin.Context) *Flash {
+	value, err := c.Cookie(flashCookieName)
+	if err != nil || value == "" {
+		return nil
+	}
+	c.SetCookie(flashCookieName, "", -1, "/", "", true, true)
+
+	payload, signature, ok := strings.Cut(value, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(signFlash(payload))) {
+		logger.WarnContext(c.Request.Context(), "Discarded flash cookie with invalid signature", "client_ip", c.ClientIP())
+		return nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil
+	}
+
+	var flash Flash
+	if err := json.Unmarshal(data, &flash); err != nil {
+		return nil
+	}
+	return &flash
+}
+
+// redirectWithError sets an error flash and sends the user back to the form
+func redirectWithError(c *gin.Context, location, message, username string) {
+	setFlash(c, "error", message, username)
+	c.Redirect(http.StatusSeeOther, location)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+	"shared/passhash"
+)
+
+// newHashPool makes a pool that reports its waits and rejections as metrics
+func newHashPool(cfg passhash.PoolConfig) *passhash.Pool {
+	pool := passhash.NewPool(cfg)
+	pool.OnStart = func(ctx context.Context, waited time.Duration) {
+		hashWaitDuration.Observe(waited.Seconds())
+		trace.SpanFromContext(ctx).AddEvent("worker acquired")
+	}
+	pool.OnReject = func(reason string) {
+		hashRejectedTotal.WithLabelValues(reason).Inc()
+	}
+	return pool
+}
+
+// hashing runs every password hash and check
+var hashing = newHashPool(defaultConfig().Password.Pool)
+
+// hashingRefused reports whether err means a password was never hashed,
+// because the pool was saturated or the client gave up waiting
+func hashingRefused(err error) bool {
+	return errors.Is(err, passhash.ErrBusy) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// respondHashingBusy asks the client to come back once the pool has
+// drained, rather than queueing more work behind it
+func respondHashingBusy(c *gin.Context) {
+	retryAfter := math.Ceil(config.Password.Pool.RetryAfter.Seconds())
+	c.Header("Retry-After", strconv.Itoa(int(retryAfter)))
+	c.String(http.StatusServiceUnavailable, "The server is busy, please try again shortly")
+}
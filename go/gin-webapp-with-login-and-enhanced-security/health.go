@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"shared/server"
+)
+
+// health answers /healthz and /readyz
+var health = server.NewHealth()
+
+// tryLockFor retries tryLock for up to timeout, so a wedged writer shows up
+// as a failing check rather than a hung probe
+func tryLockFor(tryLock func() bool, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for !tryLock() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return true
+}
+
+func registerReadinessChecks() {
+	health.AddCheck("user store", func() error {
+		if !tryLockFor(usersMutex.TryRLock, 100*time.Millisecond) {
+			return errors.New("user store lock not available")
+		}
+		usersMutex.RUnlock()
+		return nil
+	})
+	if auditor != nil {
+		health.AddCheck("audit log", auditor.check)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// Incoming request IDs are only trusted if they look like an ID, so a
+// client can't inject arbitrary text into the logs
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+type requestFieldsKey struct{}
+
+// requestFields are added to every record logged with the request context.
+// User is filled in once the session has been resolved.
+type requestFields struct {
+	RequestID string
+	Route     string
+	User      string
+}
+
+func newRequestID() string {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(bytes)
+}
+
+// requestIDMiddleware accepts the caller's X-Request-ID or generates one,
+// echoes it in the response and stores it in the request context
+func requestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(requestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		c.Header(requestIDHeader, requestID)
+		c.Set("request_id", requestID)
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		fields := &requestFields{RequestID: requestID, Route: route}
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestFieldsKey{}, fields))
+
+		trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.String("http.request_id", requestID))
+
+		c.Next()
+	}
+}
+
+// setLogUser records the authenticated user for the rest of the request's logs
+func setLogUser(c *gin.Context, username string) {
+	if fields, ok := c.Request.Context().Value(requestFieldsKey{}).(*requestFields); ok {
+		fields.User = username
+	}
+}
+
+// contextHandler adds request fields and the active trace and span IDs to
+// every record logged with a context, so logs can be joined per request and
+// to traces
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if fields, ok := ctx.Value(requestFieldsKey{}).(*requestFields); ok {
+		record.AddAttrs(
+			slog.String("request_id", fields.RequestID),
+			slog.String("route", fields.Route),
+		)
+		if fields.User != "" {
+			record.AddAttrs(slog.String("user", fields.User))
+		}
+	}
+	if spanCtx := trace.SpanContextFromContext(ctx); spanCtx.IsValid() {
+		record.AddAttrs(
+			slog.String("trace_id", spanCtx.TraceID().String()),
+			slog.String("span_id", spanCtx.SpanID().String()),
+		)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -1,61 +1,129 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 var logger *slog.Logger
 
+func newJSONHandler() slog.Handler {
+	return slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelInfo,
+	})
+}
+
 func init() {
 	// Initialize structured logging
-	logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	}))
+	logger = slog.New(contextHandler{newJSONHandler()})
 	slog.SetDefault(logger)
 }
 
-// Security headers middleware
-func securityHeadersMiddleware() gin.HandlerFunc {
+// Security headers middleware. Requests under a security.routes prefix get
+// that route's policy, everything else the default one.
+func securityHeadersMiddleware(cfg SecurityConfig) gin.HandlerFunc {
+	policies := cfg.routePolicies()
 	return gin.HandlerFunc(func(c *gin.Context) {
-		// Content Security Policy (CSP)
-		c.Header("Content-Security-Policy",
-			"default-src 'self'; "+
-				"style-src 'unsafe-inline'; "+
-				"script-src 'self'; "+
-				"img-src 'self' data:; "+
-				"font-src 'self'; "+
-				"connect-src 'self'; "+
-				"frame-ancestors 'none'; "+
-				"base-uri 'self'; "+
-				"form-action 'self'")
-
-		// Security headers
-		c.Header("Referrer-Policy", "strict-origin-when-cross-origin")
-		c.Header("Cross-Origin-Resource-Policy", "same-origin")
-		c.Header("X-Content-Type-Options", "nosniff")
-		c.Header("X-Frame-Options", "DENY")
-		c.Header("X-XSS-Protection", "1; mode=block")
-		c.Header("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+		// Fresh nonce for every response so inline tags can't be replayed
+		nonce, err := generateNonce()
+		if err != nil {
+			logger.ErrorContext(c.Request.Context(), "Failed to generate CSP nonce", "error", err.Error())
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		c.Set("csp_nonce", nonce)
+
+		// Content Security Policy (CSP) and the remaining security headers
+		policies.forPath(c.Request.URL.Path).apply(c, nonce)
+		c.Header("Reporting-Endpoints", cspReportGroup+`="`+cspReportPath+`"`)
 
 		c.Next()
 	})
 }
 
 func main() {
-	logger.Info("Starting Gin server on port 8080")
+	if handled, err := commands.Run(os.Args[1:]); handled {
+		if err != nil && !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	cfg, err := loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		logger.Error("Invalid configuration", "error", err.Error())
+		os.Exit(1)
+	}
+	config = cfg
+
+	// Redact personal data from here on, now that the rules are known
+	logger = slog.New(contextHandler{newRedactHandler(newJSONHandler(), config.Logging.Redact)})
+	slog.SetDefault(logger)
+
+	hashing = newHashPool(config.Password.Pool)
+	// Hash the dummy password now rather than on the first unknown login
+	dummyHash()
+
+	if config.Security.DevMode {
+		logger.Warn("Security dev mode enabled - HSTS is not sent")
+	}
+
+	logger.Info("Starting Gin server", "addr", config.Server.Addr, "gin_mode", config.Server.GinMode)
+
+	gin.SetMode(config.Server.GinMode)
+
+	// Restore users saved by the previous run and save them again on exit
+	if config.Store.StateFile != "" {
+		if err := loadState(config.Store.StateFile); err != nil {
+			logger.Error("Failed to restore state", "error", err.Error())
+			os.Exit(1)
+		}
+		hooks.OnShutdown("save state", func(context.Context) error {
+			return saveState(config.Store.StateFile)
+		})
+	}
+	if err := initAudit(); err != nil {
+		logger.Error("Failed to open audit log", "error", err.Error())
+		os.Exit(1)
+	}
+	hooks.StartBackground("session janitor", time.Minute, expireSessions)
+	registerReadinessChecks()
+
+	if err := initTracing(context.Background()); err != nil {
+		logger.Error("Failed to start tracing", "error", err.Error())
+		os.Exit(1)
+	}
+
+	// gin.Default's access log would bypass redaction, so only the
+	// structured request logger below is used
+	r := gin.New()
+	r.Use(gin.Recovery())
+
+	// Trace every request, continuing traces from an incoming traceparent header
+	r.Use(otelgin.Middleware(config.Tracing.ServiceName))
 
-	// Set Gin to release mode in production
-	gin.SetMode(gin.ReleaseMode)
+	// Request IDs for correlating the logs of a single request
+	r.Use(requestIDMiddleware())
 
-	r := gin.Default()
+	// Request count and latency metrics
+	r.Use(metricsMiddleware())
 
 	// Request logging middleware
 	r.Use(gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
-		logger.Info("Request",
+		logger.InfoContext(param.Request.Context(), "Request",
 			"method", param.Method,
 			"path", param.Path,
 			"status", param.StatusCode,
@@ -67,67 +135,76 @@ func main() {
 	}))
 
 	// Apply security middleware
-	r.Use(securityHeadersMiddleware())
+	r.Use(securityHeadersMiddleware(config.Security))
+
+	// Liveness and readiness probes for the platform and operators
+	r.GET("/healthz", gin.WrapF(health.Live))
+	r.GET("/readyz", gin.WrapF(health.Ready))
+
+	// Prometheus metrics, restricted to the configured scraper
+	r.GET("/metrics", metricsHandler())
+	r.GET("/admin/audit", auditQueryHandler)
+
+	// CSP violation reports sent by browsers
+	r.POST(cspReportPath, cspReportHandler)
 
 	// Public routes
 	r.GET("/", func(c *gin.Context) {
 		c.Header("Content-Type", "text/html")
-		c.String(http.StatusOK, homepage())
+		c.String(http.StatusOK, homepage(cspNonce(c)))
 	})
 
 	// Login route - GET shows form, POST processes it
 	r.GET("/login", func(c *gin.Context) {
 		c.Header("Content-Type", "text/html")
-		c.String(http.StatusOK, loginPage())
+		c.String(http.StatusOK, loginPage(cspNonce(c), popFlash(c)))
 	})
 	r.POST("/login", loginUser)
 
 	// Register route - GET shows form, POST processes it
 	r.GET("/register", func(c *gin.Context) {
 		c.Header("Content-Type", "text/html")
-		c.String(http.StatusOK, registerPage())
+		c.String(http.StatusOK, registerPage(cspNonce(c), popFlash(c)))
 	})
 	r.POST("/register", registerUser)
 
+	// Username and password rules for clients to display
+	r.GET("/policy", policyHandler)
+
 	// Logout route
 	r.POST("/logout", logoutUser)
 
-	// Protected routes - Dashboard access
-	r.GET("/protected-page", func(c *gin.Context) {
-		// Check if user has valid session
-		sessionToken, err := c.Cookie("session_token")
-		if err != nil {
-			// No session token, redirect to login
-			c.Redirect(http.StatusSeeOther, "/login")
-			return
-		}
-
-		// Find user with this session token
-		usersMutex.RLock()
-		var currentUser *User
-		for _, user := range users {
-			if user.SessionToken == sessionToken && sessionToken != "" {
-				currentUser = user
-				break
-			}
-		}
-		usersMutex.RUnlock()
+	// Embeddable widget - gets the looser "/widget" policy so other sites can frame it
+	r.GET("/widget", func(c *gin.Context) {
+		c.Header("Content-Type", "text/html")
+		c.String(http.StatusOK, widgetPage(cspNonce(c)))
+	})
 
-		if currentUser == nil {
-			// Invalid session, redirect to login
-			c.Redirect(http.StatusSeeOther, "/login")
-			return
-		}
+	// Protected routes - Dashboard access
+	r.GET("/protected-page", requireAuth(), requirePermission(PermDashboardView), func(c *gin.Context) {
+		currentUser, _ := getCurrentUser(c)
+		logger.InfoContext(c.Request.Context(), "Dashboard accessed", "username", currentUser.Username, "client_ip", c.ClientIP())
+		c.Header("Content-Type", "text/html")
+		c.String(http.StatusOK, protectedPage(cspNonce(c), currentUser))
+	})
 
-		// Valid session, show dashboard
-		logger.Info("Dashboard accessed", "username", currentUser.Username, "client_ip", c.ClientIP())
+	// Password change, also where users land after an admin forces a reset
+	r.GET("/change-password", requireAuth(), requirePermission(PermProfileUpdate), func(c *gin.Context) {
+		currentUser, _ := getCurrentUser(c)
 		c.Header("Content-Type", "text/html")
-		c.String(http.StatusOK, protectedPage(currentUser.Username, currentUser.CSRFToken))
+		c.String(http.StatusOK, changePasswordPage(cspNonce(c), currentUser.CSRFToken, currentUser.MustChangePassword, popFlash(c)))
 	})
+	r.POST("/change-password", requireAuth(), requirePermission(PermProfileUpdate), changePassword)
+
+	// Admin console for user management
+	admin := r.Group("/admin", requireAuth())
+	admin.GET("", requirePermission(PermUsersRead), adminConsole)
+	admin.POST("/users/:username/:action", requirePermissionOn(PermUsersManage, "username"), adminUserAction)
 
 	// Start server
-	logger.Info("Server starting on :8080")
-	if err := r.Run(":8080"); err != nil {
-		logger.Error("Server failed to start", "error", err.Error())
+	logger.Info("Server starting", "addr", config.Server.Addr, "tls", config.TLS.Enabled())
+	if err := runServer(r); err != nil {
+		logger.Error("Server stopped with error", "error", err.Error())
+		os.Exit(1)
 	}
 }
\ No newline at end of file
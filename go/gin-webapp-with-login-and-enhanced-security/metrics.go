@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsRegistry only holds this app's collectors plus the Go runtime and
+// process ones, so nothing registered by a library leaks into /metrics
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	httpRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests handled, by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency, by method, route and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	loginAttemptsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_login_attempts_total",
+		Help: "Login attempts, by result.",
+	}, []string{"result"})
+
+	registrationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "auth_registrations_total",
+		Help: "Registration attempts, by result.",
+	}, []string{"result"})
+
+	activeSessions = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "auth_active_sessions",
+		Help: "Users with a live session.",
+	}, countActiveSessions)
+
+	hashQueueDepth = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "password_hash_queue_depth",
+		Help: "Requests waiting for a password hashing worker.",
+	}, func() float64 { return float64(hashing.Queued()) })
+
+	hashWorkersBusy = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "password_hash_workers_busy",
+		Help: "Password hashing workers currently hashing.",
+	}, func() float64 { return float64(hashing.Running()) })
+
+	hashWaitDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "password_hash_wait_seconds",
+		Help:    "Time spent waiting for a password hashing worker.",
+		Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	})
+
+	hashRejectedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "password_hash_rejected_total",
+		Help: "Password hashes refused, by reason: queue_full, timeout or canceled.",
+	}, []string{"reason"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequestsTotal,
+		httpRequestDuration,
+		loginAttemptsTotal,
+		registrationsTotal,
+		activeSessions,
+		hashQueueDepth,
+		hashWorkersBusy,
+		hashWaitDuration,
+		hashRejectedTotal,
+	)
+}
+
+func countActiveSessions() float64 {
+	usersMutex.RLock()
+	defer usersMutex.RUnlock()
+
+	active := 0
+	for _, user := range users {
+		if user.SessionToken != "" {
+			active++
+		}
+	}
+	return float64(active)
+}
+
+// metricsMiddleware records the count and latency of every request. The
+// route template is used rather than the raw path to keep label values bounded.
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		httpRequestsTotal.WithLabelValues(c.Request.Method, route, status).Inc()
+		httpRequestDuration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// metricsHandler serves /metrics. With a token configured scrapers must send
+// it as a bearer token; without one only loopback clients are allowed.
+func metricsHandler() gin.HandlerFunc {
+	handler := promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+	return func(c *gin.Context) {
+		if !metricsAccessAllowed(c) {
+			logger.WarnContext(c.Request.Context(), "Metrics access denied", "client_ip", c.ClientIP())
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		handler.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+func metricsAccessAllowed(c *gin.Context) bool {
+	if token := config.Metrics.Token; token != "" {
+		supplied, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		return ok && subtle.ConstantTimeCompare([]byte(supplied), []byte(token)) == 1
+	}
+
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
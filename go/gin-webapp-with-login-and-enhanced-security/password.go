@@ -0,0 +1,16 @@
+package main
+
+import "shared/passhash"
+
+// dummy is checked for unknown usernames
+var dummy passhash.Dummy
+
+// dummyHash returns a hash of a random password nobody knows, made with the
+// configured algorithm and parameters
+func dummyHash() string {
+	hash, err := dummy.Hash(config.Password.Hasher())
+	if err != nil {
+		logger.Error("Failed to create dummy password hash", "error", err.Error())
+	}
+	return hash
+}
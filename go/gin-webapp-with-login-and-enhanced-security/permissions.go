@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"shared/grants"
+)
+
+// Permissions are "resource:action", optionally followed by ":instance" to
+// scope them to one resource. In grants "*" matches any part, "self" as the
+// instance matches the signed-in user's own name, and a grant without an
+// instance covers every instance.
+const (
+	PermDashboardView = "dashboard:view"
+	PermProfileUpdate = "profile:update"
+	PermUsersRead     = "users:read"
+	PermUsersManage   = "users:manage"
+	PermAuditRead     = "audit:read"
+)
+
+// rolePermissions maps each role to the permissions it grants
+var rolePermissions = map[Role][]string{
+	RoleUser:  {PermDashboardView, PermProfileUpdate, PermUsersRead + ":self"},
+	RoleAdmin: {"*:*"},
+}
+
+// can reports whether the user's role grants the permission
+func (u *User) can(permission string) bool {
+	for _, grant := range rolePermissions[roleOrDefault(u.Role)] {
+		if grants.Covers(grant, permission, userKey(u.Username)) {
+			return true
+		}
+	}
+	return false
+}
+
+// requirePermission must run after requireAuth. The user comes from the
+// session store, so a role change applies on the next request.
+func requirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		checkPermission(c, permission)
+	}
+}
+
+// requirePermissionOn checks the permission scoped to the resource named by
+// the route parameter, e.g. requirePermissionOn(PermUsersManage, "username")
+func requirePermissionOn(permission, param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		resource := c.Param(param)
+		if param == "username" {
+			// Match "self" grants whatever case the name is written in
+			resource = userKey(resource)
+		}
+		checkPermission(c, permission+":"+resource)
+	}
+}
+
+func checkPermission(c *gin.Context, permission string) {
+	currentUser, err := getCurrentUser(c)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/login")
+		c.Abort()
+		return
+	}
+	if !currentUser.can(permission) {
+		logger.WarnContext(c.Request.Context(), "Permission denied", "username", currentUser.Username, "permission", permission)
+		audit(c, AuditPermissionDenied, currentUser.Username, permission)
+		if strings.HasPrefix(permission, "users:") {
+			// The admin console answers 404 so it doesn't show it exists
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		c.String(http.StatusForbidden, "Forbidden")
+		c.Abort()
+		return
+	}
+	c.Next()
+}
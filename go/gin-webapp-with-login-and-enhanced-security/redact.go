@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/netip"
+	"slices"
+)
+
+// Prefix lengths kept when truncating client IPs, roughly a subscriber's
+// network rather than a single host
+const (
+	redactIPv4Bits = 24
+	redactIPv6Bits = 48
+)
+
+// redactHandler rewrites personal data before records are written. Hashed
+// fields are replaced by a keyed HMAC, so the same user still correlates
+// across log lines without their name appearing in the logs.
+type redactHandler struct {
+	slog.Handler
+	rules *redactRules
+}
+
+type redactRules struct {
+	key        []byte
+	hash       []string
+	truncateIP []string
+	drop       []string
+}
+
+// newRedactHandler wraps next with the configured rules. Without a hash key
+// a random one is used, so pseudonyms only correlate within one run.
+func newRedactHandler(next slog.Handler, cfg RedactConfig) slog.Handler {
+	key := []byte(cfg.HashKey)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic("failed to generate log hash key: " + err.Error())
+		}
+	}
+	return redactHandler{next, &redactRules{
+		key:        key,
+		hash:       cfg.Hash,
+		truncateIP: cfg.TruncateIP,
+		drop:       cfg.Drop,
+	}}
+}
+
+func (r *redactRules) pseudonym(value string) string {
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(value))
+	return "u_" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+func truncateIP(value string) string {
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return "invalid"
+	}
+	bits := redactIPv6Bits
+	if addr.Is4() || addr.Is4In6() {
+		addr, bits = addr.Unmap(), redactIPv4Bits
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return "invalid"
+	}
+	return prefix.String()
+}
+
+// apply returns the redacted attribute, or false if it should be dropped.
+// Rules match on the attribute key at any group depth.
+func (r *redactRules) apply(attr slog.Attr) (slog.Attr, bool) {
+	switch {
+	case slices.Contains(r.drop, attr.Key):
+		return attr, false
+	case attr.Value.Kind() == slog.KindGroup:
+		var kept []slog.Attr
+		for _, a := range attr.Value.Group() {
+			if a, ok := r.apply(a); ok {
+				kept = append(kept, a)
+			}
+		}
+		return slog.Attr{Key: attr.Key, Value: slog.GroupValue(kept...)}, true
+	case slices.Contains(r.hash, attr.Key):
+		if s := attr.Value.String(); s != "" {
+			return slog.String(attr.Key, r.pseudonym(s)), true
+		}
+	case slices.Contains(r.truncateIP, attr.Key):
+		return slog.String(attr.Key, truncateIP(attr.Value.String())), true
+	}
+	return attr, true
+}
+
+func (r *redactRules) applyAll(attrs []slog.Attr) []slog.Attr {
+	kept := make([]slog.Attr, 0, len(attrs))
+	for _, attr := range attrs {
+		if attr, ok := r.apply(attr); ok {
+			kept = append(kept, attr)
+		}
+	}
+	return kept
+}
+
+func (h redactHandler) Handle(ctx context.Context, record slog.Record) error {
+	redacted := slog.NewRecord(record.Time, record.Level, record.Message, record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		if attr, ok := h.rules.apply(attr); ok {
+			redacted.AddAttrs(attr)
+		}
+		return true
+	})
+	return h.Handler.Handle(ctx, redacted)
+}
+
+func (h redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return redactHandler{h.Handler.WithAttrs(h.rules.applyAll(attrs)), h.rules}
+}
+
+func (h redactHandler) WithGroup(name string) slog.Handler {
+	return redactHandler{h.Handler.WithGroup(name), h.rules}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// redactingLogger logs JSON lines to buf through the same handlers as the
+// server, with a fixed hash key
+func redactingLogger(buf *bytes.Buffer) *slog.Logger {
+	cfg := defaultConfig().Logging.Redact
+	cfg.HashKey = "test-key"
+	return slog.New(contextHandler{newRedactHandler(slog.NewJSONHandler(buf, nil), cfg)})
+}
+
+// lastRecord decodes the last line logged to buf
+func lastRecord(t *testing.T, buf *bytes.Buffer) map[string]any {
+	t.Helper()
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	var record map[string]any
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &record); err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+// checkRedacted asserts fields holds someone's pseudonym under each hashed
+// key, a truncated client IP and no user agent
+func checkRedacted(t *testing.T, fields map[string]any, hashed ...string) {
+	t.Helper()
+	want := (&redactRules{key: []byte("test-key")}).pseudonym("someone")
+	if !strings.HasPrefix(want, "u_") {
+		t.Fatalf("pseudonym %s doesn't start with u_", want)
+	}
+	for _, key := range hashed {
+		if fields[key] != want {
+			t.Errorf("%s is %v, want the pseudonym %s", key, fields[key], want)
+		}
+	}
+	if got := fields["client_ip"]; got != "203.0.113.0/24" {
+		t.Errorf("client_ip is %v, want 203.0.113.0/24", got)
+	}
+	if got, ok := fields["user_agent"]; ok {
+		t.Errorf("user_agent is %v, want it dropped", got)
+	}
+}
+
+func TestRedactedFields(t *testing.T) {
+	var buf bytes.Buffer
+	log := redactingLogger(&buf)
+
+	log.Info("Login", "username", "someone", "actor", "someone", "client_ip", "203.0.113.77", "user_agent", "curl/8.0", "status", 200)
+	record := lastRecord(t, &buf)
+	checkRedacted(t, record, "username", "actor")
+	if record["status"] != float64(200) {
+		t.Errorf("status is %v, want other fields untouched", record["status"])
+	}
+	if strings.Contains(buf.String(), "someone") || strings.Contains(buf.String(), "203.0.113.77") {
+		t.Errorf("personal data reached the log: %s", buf.String())
+	}
+
+	// The same name gives the same pseudonym on every line
+	first := record["username"]
+	log.Warn("Login failed", "username", "someone")
+	if got := lastRecord(t, &buf)["username"]; got != first {
+		t.Errorf("pseudonym changed from %v to %v", first, got)
+	}
+	log.Warn("Login failed", "username", "someone-else")
+	if got := lastRecord(t, &buf)["username"]; got == first {
+		t.Error("two users share a pseudonym")
+	}
+}
+
+func TestRedactedRequestUser(t *testing.T) {
+	var buf bytes.Buffer
+	log := redactingLogger(&buf)
+
+	// contextHandler adds the signed-in user, which is redacted like any
+	// other field
+	ctx := context.WithValue(context.Background(), requestFieldsKey{}, &requestFields{RequestID: "abc", Route: "/", User: "someone"})
+	log.InfoContext(ctx, "Request", "client_ip", "203.0.113.77", "user_agent", "curl/8.0")
+	record := lastRecord(t, &buf)
+	checkRedacted(t, record, "user")
+	if record["request_id"] != "abc" {
+		t.Errorf("request_id is %v, want it kept", record["request_id"])
+	}
+}
+
+func TestRedactedIPv6(t *testing.T) {
+	var buf bytes.Buffer
+	log := redactingLogger(&buf)
+
+	for addr, want := range map[string]string{
+		"2001:db8:1234:5678::1": "2001:db8:1234::/48",
+		"::ffff:203.0.113.77":   "203.0.113.0/24",
+		"not an address":        "invalid",
+	} {
+		log.Info("Request", "client_ip", addr)
+		if got := lastRecord(t, &buf)["client_ip"]; got != want {
+			t.Errorf("%s logged as %v, want %s", addr, got, want)
+		}
+	}
+}
+
+func TestRedactedInGroupsAndWithAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	log := redactingLogger(&buf)
+
+	log.Info("Admin action", slog.Group("target", "username", "someone", "client_ip", "203.0.113.77", "user_agent", "curl/8.0"))
+	target, _ := lastRecord(t, &buf)["target"].(map[string]any)
+	checkRedacted(t, target, "username")
+
+	log.With("actor", "someone", "client_ip", "203.0.113.77", "user_agent", "curl/8.0").Info("Admin action")
+	checkRedacted(t, lastRecord(t, &buf), "actor")
+
+	log.WithGroup("session").With("username", "someone").Info("Expired", "client_ip", "203.0.113.77", "user_agent", "curl/8.0")
+	session, _ := lastRecord(t, &buf)["session"].(map[string]any)
+	checkRedacted(t, session, "username")
+
+	if strings.Contains(buf.String(), "someone") || strings.Contains(buf.String(), "curl") {
+		t.Errorf("personal data reached the log: %s", buf.String())
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// headerOff removes a header that would otherwise be inherited from the default policy
+const headerOff = "off"
+
+// HeaderPolicy describes the security headers sent with a response. Empty
+// fields inherit from the default policy; CSP directives are merged one by one.
+type HeaderPolicy struct {
+	CSP                       map[string][]string `yaml:"csp"`
+	StrictTransportSecurity   string              `yaml:"strict_transport_security"`
+	PermissionsPolicy         string              `yaml:"permissions_policy"`
+	ReferrerPolicy            string              `yaml:"referrer_policy"`
+	CrossOriginOpenerPolicy   string              `yaml:"cross_origin_opener_policy"`
+	CrossOriginEmbedderPolicy string              `yaml:"cross_origin_embedder_policy"`
+	CrossOriginResourcePolicy string              `yaml:"cross_origin_resource_policy"`
+	FrameOptions              string              `yaml:"frame_options"`
+}
+
+// SecurityConfig holds the default header policy plus overrides keyed by
+// route group prefix. Dev mode drops HSTS so plain-HTTP localhost isn't pinned.
+type SecurityConfig struct {
+	DevMode bool                    `yaml:"dev_mode"`
+	Default HeaderPolicy            `yaml:"default"`
+	Routes  map[string]HeaderPolicy `yaml:"routes"`
+}
+
+// resolvedPolicy is a HeaderPolicy merged with the defaults and ready to apply
+type resolvedPolicy struct {
+	csp     *cspPolicy
+	headers map[string]string
+}
+
+func defaultSecurityConfig() SecurityConfig {
+	return SecurityConfig{
+		Default: HeaderPolicy{
+			StrictTransportSecurity:   "max-age=31536000; includeSubDomains",
+			PermissionsPolicy:         "camera=(), microphone=(), geolocation=(), payment=(), usb=()",
+			ReferrerPolicy:            "strict-origin-when-cross-origin",
+			CrossOriginOpenerPolicy:   "same-origin",
+			CrossOriginEmbedderPolicy: "require-corp",
+			CrossOriginResourcePolicy: "same-origin",
+			FrameOptions:              "DENY",
+		},
+		Routes: map[string]HeaderPolicy{
+			// The widget is meant to be framed by other sites
+			"/widget": {
+				CSP:                       map[string][]string{"frame-ancestors": {"*"}},
+				CrossOriginOpenerPolicy:   "unsafe-none",
+				CrossOriginEmbedderPolicy: headerOff,
+				CrossOriginResourcePolicy: "cross-origin",
+				FrameOptions:              headerOff,
+			},
+		},
+	}
+}
+
+// mergeHeaderPolicy overlays the non-empty fields of override onto base
+func mergeHeaderPolicy(base, override HeaderPolicy) HeaderPolicy {
+	merged := base
+	merged.CSP = make(map[string][]string, len(base.CSP)+len(override.CSP))
+	for name, sources := range base.CSP {
+		merged.CSP[name] = sources
+	}
+	for name, sources := range override.CSP {
+		merged.CSP[name] = sources
+	}
+
+	fields := []struct {
+		dst *string
+		src string
+	}{
+		{&merged.StrictTransportSecurity, override.StrictTransportSecurity},
+		{&merged.PermissionsPolicy, override.PermissionsPolicy},
+		{&merged.ReferrerPolicy, override.ReferrerPolicy},
+		{&merged.CrossOriginOpenerPolicy, override.CrossOriginOpenerPolicy},
+		{&merged.CrossOriginEmbedderPolicy, override.CrossOriginEmbedderPolicy},
+		{&merged.CrossOriginResourcePolicy, override.CrossOriginResourcePolicy},
+		{&merged.FrameOptions, override.FrameOptions},
+	}
+	for _, f := range fields {
+		if f.src != "" {
+			*f.dst = f.src
+		}
+	}
+	return merged
+}
+
+func (cfg SecurityConfig) resolve(p HeaderPolicy) *resolvedPolicy {
+	csp := defaultCSP()
+	names := make([]string, 0, len(p.CSP))
+	for name := range p.CSP {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		csp.Set(name, p.CSP[name]...)
+	}
+
+	hsts := p.StrictTransportSecurity
+	if cfg.DevMode {
+		hsts = headerOff
+	}
+
+	return &resolvedPolicy{
+		csp: csp,
+		headers: map[string]string{
+			"Strict-Transport-Security":    hsts,
+			"Permissions-Policy":           p.PermissionsPolicy,
+			"Referrer-Policy":              p.ReferrerPolicy,
+			"Cross-Origin-Opener-Policy":   p.CrossOriginOpenerPolicy,
+			"Cross-Origin-Embedder-Policy": p.CrossOriginEmbedderPolicy,
+			"Cross-Origin-Resource-Policy": p.CrossOriginResourcePolicy,
+			"X-Frame-Options":              p.FrameOptions,
+			"X-Content-Type-Options":       "nosniff",
+			"X-XSS-Protection":             "1; mode=block",
+		},
+	}
+}
+
+// DefaultPolicy returns the policy applied to every route
+func (cfg SecurityConfig) DefaultPolicy() *resolvedPolicy {
+	return cfg.resolve(mergeHeaderPolicy(HeaderPolicy{}, cfg.Default))
+}
+
+// PolicyFor returns the policy for a route group, falling back to the default
+func (cfg SecurityConfig) PolicyFor(group string) *resolvedPolicy {
+	override, ok := cfg.Routes[group]
+	if !ok {
+		return cfg.DefaultPolicy()
+	}
+	return cfg.resolve(mergeHeaderPolicy(cfg.Default, override))
+}
+
+// routePolicies holds every policy resolved once at startup, so each
+// request only has to pick one by path
+type routePolicies struct {
+	fallback *resolvedPolicy
+	prefixes []string // longest first, so the most specific route wins
+	byPrefix map[string]*resolvedPolicy
+}
+
+func (cfg SecurityConfig) routePolicies() *routePolicies {
+	policies := &routePolicies{
+		fallback: cfg.DefaultPolicy(),
+		byPrefix: make(map[string]*resolvedPolicy, len(cfg.Routes)),
+	}
+	for prefix := range cfg.Routes {
+		policies.prefixes = append(policies.prefixes, prefix)
+		policies.byPrefix[prefix] = cfg.PolicyFor(prefix)
+	}
+	sort.Slice(policies.prefixes, func(i, j int) bool {
+		return len(policies.prefixes[i]) > len(policies.prefixes[j])
+	})
+	return policies
+}
+
+// forPath returns the policy of the route group path falls under. A prefix
+// matches whole path segments only: /widget covers /widget/embed but not
+// /widgets.
+func (p *routePolicies) forPath(path string) *resolvedPolicy {
+	for _, prefix := range p.prefixes {
+		rest, ok := strings.CutPrefix(path, strings.TrimSuffix(prefix, "/"))
+		if ok && (rest == "" || rest[0] == '/') {
+			return p.byPrefix[prefix]
+		}
+	}
+	return p.fallback
+}
+
+// apply sets or removes every header covered by the policy
+func (p *resolvedPolicy) apply(c *gin.Context, nonce string) {
+	c.Header("Content-Security-Policy", p.csp.Render(nonce))
+	for name, value := range p.headers {
+		if value == "" || value == headerOff {
+			c.Writer.Header().Del(name)
+			continue
+		}
+		c.Header(name, value)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// appliedHeaders returns the headers policy sets on a response that
+// already carries every header it could remove
+func appliedHeaders(policy *resolvedPolicy) http.Header {
+	gin.SetMode(gin.TestMode)
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	for name := range policy.headers {
+		c.Header(name, "inherited")
+	}
+	policy.apply(c, "test-nonce")
+	return rec.Header()
+}
+
+func TestRoutePoliciesMatchWholeSegments(t *testing.T) {
+	cfg := defaultSecurityConfig()
+	cfg.Routes["/widget/embed"] = HeaderPolicy{ReferrerPolicy: "no-referrer"}
+	policies := cfg.routePolicies()
+
+	for _, tc := range []struct {
+		path string
+		want *resolvedPolicy
+	}{
+		{"/widget", policies.byPrefix["/widget"]},
+		{"/widget/", policies.byPrefix["/widget"]},
+		{"/widget/settings", policies.byPrefix["/widget"]},
+		{"/widget/embed", policies.byPrefix["/widget/embed"]},
+		{"/widget/embed/frame", policies.byPrefix["/widget/embed"]},
+		{"/widgets", policies.fallback},
+		{"/widget-admin", policies.fallback},
+		{"/", policies.fallback},
+	} {
+		if got := policies.forPath(tc.path); got != tc.want {
+			t.Errorf("%s got the wrong policy", tc.path)
+		}
+	}
+}
+
+func TestRoutePolicyOverrides(t *testing.T) {
+	cfg := defaultSecurityConfig()
+	cfg.Routes["/widget"] = HeaderPolicy{
+		CSP:          map[string][]string{"frame-ancestors": {"https://partner.example"}, "img-src": {"*"}},
+		FrameOptions: headerOff,
+	}
+	headers := appliedHeaders(cfg.PolicyFor("/widget"))
+
+	if got := headers.Get("X-Frame-Options"); got != "" {
+		t.Errorf(`X-Frame-Options is %q, want it removed by "off"`, got)
+	}
+	// Fields the route leaves empty are inherited
+	if got, want := headers.Get("Cross-Origin-Opener-Policy"), cfg.Default.CrossOriginOpenerPolicy; got != want {
+		t.Errorf("Cross-Origin-Opener-Policy is %q, want the default %q", got, want)
+	}
+
+	csp := headers.Get("Content-Security-Policy")
+	for _, want := range []string{
+		"frame-ancestors https://partner.example",
+		"img-src *",
+		// Directives the route doesn't set keep their defaults
+		"default-src 'self'",
+		"script-src 'nonce-test-nonce' 'strict-dynamic'",
+	} {
+		if !strings.Contains(csp, want) {
+			t.Errorf("CSP %q is missing %q", csp, want)
+		}
+	}
+	if strings.Contains(csp, "frame-ancestors 'none'") {
+		t.Errorf("CSP %q kept the default frame-ancestors", csp)
+	}
+}
+
+func TestDefaultPolicyLeavesOtherRoutesAlone(t *testing.T) {
+	headers := appliedHeaders(defaultSecurityConfig().DefaultPolicy())
+	if got := headers.Get("X-Frame-Options"); got != "DENY" {
+		t.Errorf("X-Frame-Options is %q, want DENY", got)
+	}
+	if csp := headers.Get("Content-Security-Policy"); !strings.Contains(csp, "frame-ancestors 'none'") {
+		t.Errorf("CSP %q doesn't forbid framing", csp)
+	}
+}
+
+func TestDevModeDropsHSTS(t *testing.T) {
+	cfg := defaultSecurityConfig()
+	if got := appliedHeaders(cfg.DefaultPolicy()).Get("Strict-Transport-Security"); got != cfg.Default.StrictTransportSecurity {
+		t.Errorf("Strict-Transport-Security is %q, want %q", got, cfg.Default.StrictTransportSecurity)
+	}
+
+	cfg.DevMode = true
+	for _, policy := range []*resolvedPolicy{cfg.DefaultPolicy(), cfg.PolicyFor("/widget")} {
+		if got := appliedHeaders(policy).Get("Strict-Transport-Security"); got != "" {
+			t.Errorf("Strict-Transport-Security is %q in dev mode, want it removed", got)
+		}
+	}
+}
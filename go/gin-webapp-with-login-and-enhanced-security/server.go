@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+
+	"shared/server"
+)
+
+// hooks holds the work done once the server has drained, such as stopping
+// background goroutines and saving state
+var hooks server.Hooks
+
+// runServer serves handler, over TLS when configured, until SIGINT, SIGTERM
+// or a listener fails, then drains and runs the shutdown hooks within
+// shutdown_timeout
+func runServer(handler http.Handler) error {
+	return server.Run(handler, server.Options{
+		Addr:            config.Server.Addr,
+		ShutdownTimeout: config.Server.ShutdownTimeout,
+		DrainDelay:      config.Server.DrainDelay,
+		Health:          health,
+
+		CertFile:     config.TLS.CertFile,
+		KeyFile:      config.TLS.KeyFile,
+		RedirectAddr: config.TLS.RedirectAddr,
+
+		Hooks:  &hooks,
+		Logger: logger,
+	})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// loadState restores the users (and their sessions) saved by a previous run.
+// A missing file is not an error, it just means there is nothing to restore.
+func loadState(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading state file: %w", err)
+	}
+
+	loaded := make(map[string]*User)
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("parsing state file %s: %w", path, err)
+	}
+
+	byKey, bySkeleton, err := indexUsers(loaded)
+	if err != nil {
+		return fmt.Errorf("loading state file %s: %w", path, err)
+	}
+	usersMutex.Lock()
+	users, skeletons = byKey, bySkeleton
+	usersMutex.Unlock()
+
+	logger.Info("Restored state", "path", path, "users", len(byKey))
+	return nil
+}
+
+// saveState writes the users to path, replacing it atomically so a crash
+// mid-write never leaves a truncated file behind
+func saveState(path string) error {
+	usersMutex.RLock()
+	data, err := json.Marshal(users)
+	count := len(users)
+	usersMutex.RUnlock()
+	if err != nil {
+		return fmt.Errorf("encoding state: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".state-*")
+	if err != nil {
+		return fmt.Errorf("creating state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	// Password hashes and session tokens live in here, so keep it private
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("creating state file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replacing state file: %w", err)
+	}
+
+	logger.Info("Saved state", "path", path, "users", count)
+	return nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"shared/authpolicy"
+)
+
+// Role controls what a user may do. Users saved before roles existed have
+// an empty role, which counts as RoleUser.
+type Role string
+
+const (
+	RoleUser  Role = "user"
+	RoleAdmin Role = "admin"
+)
+
+type User struct {
+	Username           string
+	PasswordHash       string
+	Role               Role
+	Locked             bool
+	MustChangePassword bool
+	SessionToken       string
+	CSRFToken          string
+	SessionExpiresAt   time.Time
+}
+
+// roleOrDefault maps the empty role of older saved users to RoleUser
+func roleOrDefault(role Role) Role {
+	if role == "" {
+		return RoleUser
+	}
+	return role
+}
+
+// clearSession invalidates the user's session and CSRF tokens
+func (u *User) clearSession() {
+	u.SessionToken = ""
+	u.CSRFToken = ""
+	u.SessionExpiresAt = time.Time{}
+}
+
+// users is keyed by userKey, so lookups ignore case while User.Username
+// keeps the name as it was registered. skeletons maps each of a user's
+// authpolicy.Skeletons to their key, to catch lookalike names.
+var (
+	users      = make(map[string]*User)
+	skeletons  = make(map[string]string)
+	usersMutex sync.RWMutex
+)
+
+var (
+	errUserExists         = errors.New("user already exists")
+	errUserNotFound       = errors.New("user not found")
+	errUsernameConfusable = errors.New("username looks like an existing one")
+)
+
+// userKey is the store key for a username in any case
+func userKey(username string) string {
+	return config.Policy.Username.Key(username)
+}
+
+// indexUsers rebuilds the user and skeleton maps from saved users, which
+// may be keyed by their name as registered. Names that now fold to the same
+// key can't both log in, so they are refused rather than one being dropped.
+func indexUsers(saved map[string]*User) (map[string]*User, map[string]string, error) {
+	names := make([]string, 0, len(saved))
+	for name := range saved {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	byKey := make(map[string]*User, len(saved))
+	bySkeleton := make(map[string]string, len(saved))
+	var collisions []string
+	for _, name := range names {
+		user := saved[name]
+		key := userKey(user.Username)
+		if other, taken := byKey[key]; taken {
+			collisions = append(collisions, fmt.Sprintf("%q and %q", other.Username, user.Username))
+			continue
+		}
+		byKey[key] = user
+		for _, skeleton := range authpolicy.Skeletons(user.Username) {
+			bySkeleton[skeleton] = key
+		}
+	}
+	if len(collisions) > 0 {
+		return nil, nil, fmt.Errorf("saved usernames differ only by case: %s; rename or remove one of each", strings.Join(collisions, ", "))
+	}
+	return byKey, bySkeleton, nil
+}
+
+// startStoreSpan starts a span for a store operation. The lock is taken
+// inside the span, and an event marks when it was acquired so contention
+// is visible in the trace.
+func startStoreSpan(ctx context.Context, op string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "store."+op, trace.WithAttributes(attribute.String("store.operation", op)))
+}
+
+// getUser returns a copy of the named user
+func getUser(ctx context.Context, username string) (*User, bool) {
+	_, span := startStoreSpan(ctx, "GetUser")
+	defer span.End()
+
+	usersMutex.RLock()
+	span.AddEvent("lock acquired")
+	defer usersMutex.RUnlock()
+
+	user, exists := users[userKey(username)]
+	if !exists {
+		return nil, false
+	}
+	userCopy := *user
+	return &userCopy, true
+}
+
+// createUser adds a user, failing with errUserExists if the name is taken in
+// any case, or errUsernameConfusable if it looks like another user's name
+func createUser(ctx context.Context, user *User) error {
+	_, span := startStoreSpan(ctx, "CreateUser")
+	defer span.End()
+
+	usersMutex.Lock()
+	span.AddEvent("lock acquired")
+	defer usersMutex.Unlock()
+
+	key := userKey(user.Username)
+	if _, exists := users[key]; exists {
+		return errUserExists
+	}
+	userSkeletons := authpolicy.Skeletons(user.Username)
+	if config.Policy.Username.RejectConfusable {
+		for _, skeleton := range userSkeletons {
+			if _, lookalike := skeletons[skeleton]; lookalike {
+				return errUsernameConfusable
+			}
+		}
+	}
+	userCopy := *user
+	users[key] = &userCopy
+	for _, skeleton := range userSkeletons {
+		skeletons[skeleton] = key
+	}
+	return nil
+}
+
+// startSession stores new session and CSRF tokens for the user
+func startSession(ctx context.Context, username, sessionToken, csrfToken string) {
+	_, span := startStoreSpan(ctx, "StartSession")
+	defer span.End()
+
+	usersMutex.Lock()
+	span.AddEvent("lock acquired")
+	defer usersMutex.Unlock()
+
+	if user, exists := users[userKey(username)]; exists {
+		user.SessionToken = sessionToken
+		user.CSRFToken = csrfToken
+		user.SessionExpiresAt = time.Now().Add(config.Session.CookieMaxAge)
+	}
+}
+
+// findUserBySession returns a copy of the user owning the session token
+func findUserBySession(ctx context.Context, sessionToken string) (*User, bool) {
+	_, span := startStoreSpan(ctx, "FindSession")
+	defer span.End()
+
+	if sessionToken == "" {
+		return nil, false
+	}
+
+	usersMutex.RLock()
+	span.AddEvent("lock acquired")
+	defer usersMutex.RUnlock()
+
+	for _, user := range users {
+		if tokensEqual(sessionToken, user.SessionToken) {
+			userCopy := *user
+			return &userCopy, true
+		}
+	}
+	return nil, false
+}
+
+// endSession clears the user's session and CSRF tokens
+func endSession(ctx context.Context, username string) {
+	_, span := startStoreSpan(ctx, "EndSession")
+	defer span.End()
+
+	usersMutex.Lock()
+	span.AddEvent("lock acquired")
+	defer usersMutex.Unlock()
+
+	if user, exists := users[userKey(username)]; exists {
+		user.clearSession()
+	}
+}
+
+// listUsers returns copies of all users, sorted by username
+func listUsers(ctx context.Context) []User {
+	_, span := startStoreSpan(ctx, "ListUsers")
+	defer span.End()
+
+	usersMutex.RLock()
+	span.AddEvent("lock acquired")
+	defer usersMutex.RUnlock()
+
+	list := make([]User, 0, len(users))
+	for _, user := range users {
+		list = append(list, *user)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Username < list[j].Username })
+	return list
+}
+
+// updateUser applies fn to the named user under the store lock
+func updateUser(ctx context.Context, username string, fn func(*User)) error {
+	_, span := startStoreSpan(ctx, "UpdateUser")
+	defer span.End()
+
+	usersMutex.Lock()
+	span.AddEvent("lock acquired")
+	defer usersMutex.Unlock()
+
+	user, exists := users[userKey(username)]
+	if !exists {
+		return errUserNotFound
+	}
+	fn(user)
+	return nil
+}
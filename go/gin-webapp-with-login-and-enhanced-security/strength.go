@@ -0,0 +1,516 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Strength estimation in the style of zxcvbn: the password is split into the
+// cheapest sequence of recognisable patterns (common passwords, the user's
+// own name, sequences, repeats, keyboard walks, dates), each with an estimate
+// of how many guesses an attacker needs to reach it, and anything left over
+// is treated as brute force. The total becomes a 0-4 score.
+
+// commonPasswords is ranked by popularity; a match costs its rank in guesses
+var commonPasswords = rankedWords(`password 123456 123456789 qwerty 12345678
+	111111 1234567 sunshine iloveyou princess admin welcome 666666 abc123
+	football 123123 monkey 654321 charlie aa123456 donald letmein dragon
+	baseball master hello freedom whatever qazwsx trustno1 login passw0rd
+	starwars shadow superman michael jennifer hunter batman access secret
+	killer jordan soccer hockey mustang ranger buster thomas tigger robert
+	summer winter spring autumn love pepper ginger daniel computer matrix
+	internet samsung google apple orange banana chocolate cookie flower
+	angel jesus christ family forever friend friends lovely summer hannah
+	maggie jessica ashley nicole michelle amanda andrew joshua matthew
+	anthony william george harley cheese chelsea arsenal liverpool yankees
+	dolphin purple silver golden diamond london paris berlin america canada
+	changeme default guest root user test testing demo example sample
+	qwertyuiop asdfgh zxcvbn 1q2w3e4r zaq1zaq1 password1 iloveu lovely
+	whatever1 nothing secure security private business office company`)
+
+// Keyboard rows, shifted and unshifted, for spotting walks like "qwerty"
+var keyboardRows = []string{
+	"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./",
+	"~!@#$%^&*()_+", "QWERTYUIOP{}|", "ASDFGHJKL:\"", "ZXCVBNM<>?",
+}
+
+var leetSubstitutions = map[rune]rune{
+	'4': 'a', '@': 'a', '8': 'b', '(': 'c', '3': 'e', '6': 'g', '1': 'i',
+	'!': 'i', '|': 'l', '0': 'o', '$': 's', '5': 's', '7': 't', '+': 't', '2': 'z',
+}
+
+const (
+	// Finding the cheapest cover is cubic in length, so only this many runes
+	// are scored. Anything after them can only make a password stronger.
+	maxScoredRunes = 100
+
+	bruteforceCardinality = 10
+	minSubmatchGuesses    = 50
+	// Each extra pattern costs this much, so long chains of tiny
+	// matches aren't preferred over one brute-force run
+	minGuessesBeforeGrowing = 10000
+)
+
+type strengthMatch struct {
+	i, j    int // rune offsets, j exclusive
+	pattern string
+	guesses float64
+	altered bool // dictionary match that needed l33t, case or reversal
+}
+
+// Strength is the estimator's verdict. Warning names the weakest pattern
+// found, if any.
+type Strength struct {
+	Score   int
+	Guesses float64
+	Warning string
+}
+
+func rankedWords(list string) map[string]int {
+	ranks := make(map[string]int)
+	for i, word := range strings.Fields(list) {
+		if _, seen := ranks[word]; !seen {
+			ranks[word] = i + 1
+		}
+	}
+	return ranks
+}
+
+// estimateStrength scores password; userInputs such as the username count
+// as the most obvious guesses of all
+func estimateStrength(password string, userInputs ...string) Strength {
+	var runes []rune
+	for _, r := range password {
+		if len(runes) == maxScoredRunes {
+			break
+		}
+		runes = append(runes, r)
+	}
+	if len(runes) == 0 {
+		return Strength{Warning: "Password is empty"}
+	}
+
+	userWords := make(map[string]int)
+	for _, input := range userInputs {
+		if input = strings.ToLower(strings.TrimSpace(input)); len(input) >= 3 {
+			userWords[input] = 1
+		}
+	}
+
+	var matches []strengthMatch
+	matches = append(matches, dictionaryMatches(runes, commonPasswords, "dictionary")...)
+	matches = append(matches, dictionaryMatches(runes, userWords, "user_input")...)
+	matches = append(matches, sequenceMatches(runes)...)
+	matches = append(matches, repeatMatches(runes)...)
+	matches = append(matches, keyboardMatches(runes)...)
+	matches = append(matches, dateMatches(runes)...)
+
+	guesses, best := mostGuessableSequence(runes, matches)
+	return Strength{
+		Score:   scoreGuesses(guesses),
+		Guesses: guesses,
+		Warning: strengthWarning(best, len(runes)),
+	}
+}
+
+func scoreGuesses(guesses float64) int {
+	switch {
+	case guesses < 1e3+5:
+		return 0
+	case guesses < 1e6+5:
+		return 1
+	case guesses < 1e8+5:
+		return 2
+	case guesses < 1e10+5:
+		return 3
+	}
+	return 4
+}
+
+// mostGuessableSequence finds the cover of the password with the fewest
+// total guesses, filling gaps with brute force. Guesses are combined as
+// zxcvbn does: k! * product(guesses) + 10000^(k-1) for k patterns.
+func mostGuessableSequence(runes []rune, matches []strengthMatch) (float64, []strengthMatch) {
+	n := len(runes)
+	byEnd := make([][]strengthMatch, n+1)
+	for _, m := range matches {
+		byEnd[m.j] = append(byEnd[m.j], m)
+	}
+	for j := 1; j <= n; j++ {
+		for i := 0; i < j; i++ {
+			byEnd[j] = append(byEnd[j], strengthMatch{i: i, j: j, pattern: "bruteforce", guesses: bruteforceGuesses(j - i)})
+		}
+	}
+
+	// logProduct[k][j] is the smallest log10 product of k patterns covering
+	// runes[:j]; from[k][j] is the last of those patterns
+	logProduct := make([][]float64, n+1)
+	from := make([][]*strengthMatch, n+1)
+	for k := range logProduct {
+		logProduct[k] = make([]float64, n+1)
+		from[k] = make([]*strengthMatch, n+1)
+		for j := range logProduct[k] {
+			logProduct[k][j] = math.Inf(1)
+		}
+	}
+	logProduct[0][0] = 0
+	for j := 1; j <= n; j++ {
+		for idx := range byEnd[j] {
+			m := &byEnd[j][idx]
+			for k := 1; k <= j; k++ {
+				prev := logProduct[k-1][m.i]
+				if math.IsInf(prev, 1) {
+					continue
+				}
+				if total := prev + math.Log10(m.guesses); total < logProduct[k][j] {
+					logProduct[k][j], from[k][j] = total, m
+				}
+			}
+		}
+	}
+
+	bestLog, bestK := math.Inf(1), 0
+	for k := 1; k <= n; k++ {
+		if math.IsInf(logProduct[k][n], 1) {
+			continue
+		}
+		lgamma, _ := math.Lgamma(float64(k + 1))
+		factorial := lgamma / math.Ln10
+		total := logSum(factorial+logProduct[k][n], float64(k-1)*math.Log10(minGuessesBeforeGrowing))
+		if total < bestLog {
+			bestLog, bestK = total, k
+		}
+	}
+
+	sequence := make([]strengthMatch, bestK)
+	for k, j := bestK, n; k > 0; k-- {
+		m := from[k][j]
+		sequence[k-1] = *m
+		j = m.i
+	}
+	return math.Pow(10, bestLog), sequence
+}
+
+// logSum returns log10(10^a + 10^b) without overflowing
+func logSum(a, b float64) float64 {
+	if a < b {
+		a, b = b, a
+	}
+	return a + math.Log10(1+math.Pow(10, b-a))
+}
+
+func bruteforceGuesses(length int) float64 {
+	guesses := math.Pow(bruteforceCardinality, float64(length))
+	if length == 1 {
+		return max(guesses, minSubmatchGuesses/5+1)
+	}
+	return max(guesses, minSubmatchGuesses+1)
+}
+
+// dictionaryMatches finds words from ranks anywhere in the password, also
+// after undoing l33t substitutions and reversal
+func dictionaryMatches(runes []rune, ranks map[string]int, pattern string) []strengthMatch {
+	if len(ranks) == 0 {
+		return nil
+	}
+	n := len(runes)
+	lower := make([]rune, n)
+	unleet := make([]rune, n)
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+		unleet[i] = lower[i]
+		if sub, ok := leetSubstitutions[lower[i]]; ok {
+			unleet[i] = sub
+		}
+	}
+
+	var matches []strengthMatch
+	for i := 0; i < n; i++ {
+		for j := i + 3; j <= n; j++ {
+			token := runes[i:j]
+			candidates := []struct {
+				word     string
+				leet     bool
+				reversed bool
+			}{
+				{string(lower[i:j]), false, false},
+				{string(unleet[i:j]), true, false},
+				{reverseString(string(lower[i:j])), false, true},
+			}
+			best := math.Inf(1)
+			altered := false
+			for _, c := range candidates {
+				rank, ok := ranks[c.word]
+				if !ok || (c.leet && c.word == string(lower[i:j])) {
+					continue
+				}
+				guesses := float64(rank) * uppercaseVariations(token)
+				if c.leet {
+					guesses *= leetVariations(lower[i:j])
+				}
+				if c.reversed {
+					guesses *= 2
+				}
+				if guesses < best {
+					best = guesses
+					altered = c.leet || c.reversed || uppercaseVariations(token) > 1
+				}
+			}
+			if !math.IsInf(best, 1) {
+				matches = append(matches, strengthMatch{
+					i: i, j: j, pattern: pattern,
+					guesses: max(best, minSubmatchGuesses),
+					altered: altered,
+				})
+			}
+		}
+	}
+	return matches
+}
+
+func reverseString(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+// uppercaseVariations is how many ways the capitals in token could have
+// been placed, cheap for the usual first-letter or all-caps forms
+func uppercaseVariations(token []rune) float64 {
+	upper, lower := 0, 0
+	for _, r := range token {
+		switch {
+		case unicode.IsUpper(r):
+			upper++
+		case unicode.IsLower(r):
+			lower++
+		}
+	}
+	if upper == 0 {
+		return 1
+	}
+	if lower == 0 || (upper == 1 && unicode.IsUpper(token[0])) || (upper == 1 && unicode.IsUpper(token[len(token)-1])) {
+		return 2
+	}
+	return binomialSum(upper+lower, min(upper, lower))
+}
+
+func leetVariations(token []rune) float64 {
+	subbed := 0
+	for _, r := range token {
+		if _, ok := leetSubstitutions[r]; ok {
+			subbed++
+		}
+	}
+	return max(2, binomialSum(len(token), subbed))
+}
+
+// binomialSum returns C(n,1) + ... + C(n,k)
+func binomialSum(n, k int) float64 {
+	total, c := 0.0, 1.0
+	for i := 1; i <= k; i++ {
+		c = c * float64(n-i+1) / float64(i)
+		total += c
+	}
+	return max(total, 1)
+}
+
+// sequenceMatches finds runs with a constant step of one, like "abc" or "9876"
+func sequenceMatches(runes []rune) []strengthMatch {
+	var matches []strengthMatch
+	for i := 0; i+2 < len(runes); {
+		delta := runes[i+1] - runes[i]
+		j := i + 1
+		for j+1 < len(runes) && runes[j+1]-runes[j] == delta {
+			j++
+		}
+		if (delta == 1 || delta == -1) && j-i+1 >= 3 {
+			base := 26.0
+			switch first := unicode.ToLower(runes[i]); {
+			case strings.ContainsRune("aAzZ019", first):
+				base = 4
+			case unicode.IsDigit(first):
+				base = 10
+			}
+			guesses := base * float64(j-i+1)
+			if delta < 0 {
+				guesses *= 2
+			}
+			matches = append(matches, strengthMatch{i: i, j: j + 1, pattern: "sequence", guesses: max(guesses, minSubmatchGuesses)})
+			i = j + 1
+			continue
+		}
+		i++
+	}
+	return matches
+}
+
+// repeatMatches finds a block repeated back to back, like "aaa" or
+// "abcabc", keeping the repeat that covers the most at each position
+func repeatMatches(runes []rune) []strengthMatch {
+	n := len(runes)
+	var matches []strengthMatch
+	for i := 0; i < n; {
+		bestSize, bestCount := 0, 0
+		for size := 1; i+2*size <= n; size++ {
+			block := string(runes[i : i+size])
+			count := 1
+			for i+(count+1)*size <= n && string(runes[i+count*size:i+(count+1)*size]) == block {
+				count++
+			}
+			if (count >= 3 || (count == 2 && size > 1)) && count*size > bestCount*bestSize {
+				bestSize, bestCount = size, count
+			}
+		}
+		if bestCount == 0 {
+			i++
+			continue
+		}
+		baseGuesses := estimateStrength(string(runes[i : i+bestSize])).Guesses
+		matches = append(matches, strengthMatch{
+			i: i, j: i + bestCount*bestSize, pattern: "repeat",
+			guesses: max(baseGuesses*float64(bestCount), minSubmatchGuesses),
+		})
+		i += bestCount * bestSize
+	}
+	return matches
+}
+
+func keyPosition(r rune) (row, col int, ok bool) {
+	for row, keys := range keyboardRows {
+		if col := strings.IndexRune(keys, r); col >= 0 {
+			return row % 4, col, true
+		}
+	}
+	return 0, 0, false
+}
+
+// adjacentKeys reports whether b is next to a on a QWERTY keyboard
+func adjacentKeys(a, b rune) bool {
+	ra, ca, okA := keyPosition(a)
+	rb, cb, okB := keyPosition(b)
+	if !okA || !okB || (ra == rb && ca == cb) {
+		return false
+	}
+	dr, dc := rb-ra, cb-ca
+	return (dr == 0 && (dc == 1 || dc == -1)) || (dr == 1 && (dc == 0 || dc == -1)) || (dr == -1 && (dc == 0 || dc == 1))
+}
+
+// keyboardMatches finds walks of at least three neighbouring keys
+func keyboardMatches(runes []rune) []strengthMatch {
+	var matches []strengthMatch
+	for i := 0; i+2 < len(runes); {
+		j, turns, lastDir := i, 1, 0
+		for j+1 < len(runes) && adjacentKeys(runes[j], runes[j+1]) {
+			r1, c1, _ := keyPosition(runes[j])
+			r2, c2, _ := keyPosition(runes[j+1])
+			dir := (r2-r1)*10 + (c2 - c1)
+			if j > i && dir != lastDir {
+				turns++
+			}
+			lastDir = dir
+			j++
+		}
+		if length := j - i + 1; length >= 3 {
+			// Starting keys times directions per turn, over the walk's length
+			guesses := 47 * math.Pow(4, float64(turns)) * float64(length)
+			matches = append(matches, strengthMatch{i: i, j: j + 1, pattern: "keyboard", guesses: max(guesses, minSubmatchGuesses)})
+			i = j + 1
+			continue
+		}
+		i++
+	}
+	return matches
+}
+
+// dateMatches finds plausible years and digit-only dates like 31121999
+func dateMatches(runes []rune) []strengthMatch {
+	now := time.Now().Year()
+	yearGuesses := func(year int) float64 {
+		return math.Max(math.Abs(float64(year-now)), 20)
+	}
+
+	var matches []strengthMatch
+	for i := 0; i < len(runes); i++ {
+		for _, size := range []int{4, 6, 8} {
+			j := i + size
+			if j > len(runes) {
+				break
+			}
+			digits := string(runes[i:j])
+			value, err := strconv.Atoi(digits)
+			if err != nil || strings.ContainsAny(digits, "+-") {
+				continue
+			}
+			switch size {
+			case 4:
+				if value >= 1900 && value <= now+20 {
+					matches = append(matches, strengthMatch{i: i, j: j, pattern: "date", guesses: max(yearGuesses(value), minSubmatchGuesses)})
+				}
+			case 6, 8:
+				if year, ok := parseDigitDate(digits); ok {
+					matches = append(matches, strengthMatch{i: i, j: j, pattern: "date", guesses: max(365*yearGuesses(year), minSubmatchGuesses)})
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// parseDigitDate accepts day-month-year and year-month-day orders with two
+// or four digit years
+func parseDigitDate(digits string) (int, bool) {
+	layouts := map[int][]string{
+		6: {"020106", "010206", "060102"},
+		8: {"02012006", "01022006", "20060102"},
+	}
+	for _, layout := range layouts[len(digits)] {
+		if t, err := time.Parse(layout, digits); err == nil {
+			return t.Year(), true
+		}
+	}
+	return 0, false
+}
+
+// strengthWarning explains the weakest part of the password
+func strengthWarning(sequence []strengthMatch, length int) string {
+	var longest *strengthMatch
+	for i := range sequence {
+		if m := &sequence[i]; m.pattern != "bruteforce" && (longest == nil || m.j-m.i > longest.j-longest.i) {
+			longest = m
+		}
+	}
+	if longest == nil {
+		if length < 12 {
+			return "Short passwords are easy to guess"
+		}
+		return ""
+	}
+
+	switch longest.pattern {
+	case "dictionary":
+		if longest.j-longest.i == length && !longest.altered {
+			return "This is a very common password"
+		}
+		if longest.altered {
+			return "This is similar to a commonly used password"
+		}
+		return "Common passwords are easy to guess, even inside a longer one"
+	case "user_input":
+		return "Passwords containing your username are easy to guess"
+	case "sequence":
+		return "Sequences like abc or 6543 are easy to guess"
+	case "repeat":
+		return "Repeats like aaa or abcabc are easy to guess"
+	case "keyboard":
+		return "Straight rows or short patterns of keys are easy to guess"
+	case "date":
+		return "Dates and years are easy to guess"
+	}
+	return ""
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"shared/passhash"
+)
+
+func TestEstimateStrength(t *testing.T) {
+	for _, tt := range []struct {
+		password string
+		score    int
+		warning  string
+	}{
+		{"password", 0, "This is a very common password"},
+		{"P@ssw0rd", 0, "This is similar to a commonly used password"},
+		{"Password123", 1, "This is similar to a commonly used password"},
+		{"qwerty123", 1, "Common passwords are easy to guess, even inside a longer one"},
+		{"alice2024", 1, "Passwords containing your username are easy to guess"},
+		{"aaaaaaaaaaaa", 0, "Repeats like aaa or abcabc are easy to guess"},
+		{"abcdefgh", 0, "Sequences like abc or 6543 are easy to guess"},
+		{"zxcvbnm,./", 1, "Straight rows or short patterns of keys are easy to guess"},
+		{"31121999", 1, "Dates and years are easy to guess"},
+		{"Xk9#mQ2$vL7!pR", 4, ""},
+		{"correct horse battery staple", 4, ""},
+	} {
+		got := estimateStrength(tt.password, "alice")
+		if got.Score != tt.score || got.Warning != tt.warning {
+			t.Errorf("%q scored %d (%q), want %d (%q)", tt.password, got.Score, got.Warning, tt.score, tt.warning)
+		}
+	}
+}
+
+func TestScreenPasswordRefusesWeakPasswords(t *testing.T) {
+	config = defaultConfig()
+
+	var weak *weakPasswordError
+	if err := screenPassword(context.Background(), "alice", "Password123"); !errors.As(err, &weak) {
+		t.Errorf("Password123 was not refused: %v", err)
+	}
+	if err := screenPassword(context.Background(), "alice", "quiet-lantern-orbit-47"); err != nil {
+		t.Errorf("a strong password was refused: %v", err)
+	}
+}
+
+// Scoring takes a hashing worker, so a saturated pool refuses it like a hash
+func TestScreenPasswordWaitsForAHashingWorker(t *testing.T) {
+	config = defaultConfig()
+	saved := hashing
+	defer func() { hashing = saved }()
+	hashing = passhash.NewPool(passhash.PoolConfig{Workers: 1})
+
+	busy := make(chan struct{})
+	release := make(chan struct{})
+	go hashing.Do(context.Background(), func() {
+		close(busy)
+		<-release
+	})
+	<-busy
+	defer close(release)
+
+	if err := screenPassword(context.Background(), "alice", "quiet-lantern-orbit-47"); !errors.Is(err, passhash.ErrBusy) {
+		t.Errorf("got %v, want the pool's busy error", err)
+	}
+}
+
+// Only the start of a very long password is scored, so the cost of scoring
+// has a bound whatever the length limit
+func TestEstimateStrengthScoresOnlyTheStart(t *testing.T) {
+	long := strings.Repeat("ab1", 10000)
+	if got, want := estimateStrength(long), estimateStrength(long[:maxScoredRunes]); got != want {
+		t.Errorf("long password scored %+v, its first %d runes %+v", got, maxScoredRunes, want)
+	}
+}
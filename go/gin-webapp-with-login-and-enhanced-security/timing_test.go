@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"shared/timingstats"
+)
+
+// setupTiming registers one user and uses hashing parameters heavy enough
+// that password checks, not request handling, dominate a login
+func setupTiming(tb testing.TB) {
+	tb.Helper()
+	gin.SetMode(gin.TestMode)
+	logger = slog.New(slog.DiscardHandler)
+	config = defaultConfig()
+	config.Password.Argon2.MemoryKiB = 1024
+	config.Password.Argon2.Iterations = 1
+	hashing = newHashPool(config.Password.Pool)
+	hashRuns = timingstats.CountStarts(hashing)
+	if err := config.Policy.Compile(); err != nil {
+		tb.Fatal(err)
+	}
+	users, skeletons = make(map[string]*User), make(map[string]string)
+
+	ctx := context.Background()
+	hashed, err := hashPassword(ctx, "Correct-Horse-42")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := createUser(ctx, &User{Username: "someone", PasswordHash: hashed, Role: RoleUser}); err != nil {
+		tb.Fatal(err)
+	}
+	dummyHash()
+}
+
+// hashRuns counts the jobs the hash pool has started
+var hashRuns *atomic.Int64
+
+func timeLogin(username string) time.Duration {
+	form := url.Values{"username": {username}, "password": {"Not-The-Password-1"}}
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	start := time.Now()
+	loginUser(c)
+	elapsed := time.Since(start)
+	// Refusals redirect back to the login page
+	if rec.Header().Get("Location") != "/login" {
+		panic("login with a wrong password was not refused")
+	}
+	return elapsed
+}
+
+// A wrong password for an existing user and any password for an unknown
+// one should take the same time, or timing tells which usernames exist
+func TestLoginTimingParity(t *testing.T) {
+	setupTiming(t)
+	timingstats.AssertParity(t, 200,
+		func() time.Duration { return timeLogin("someone") },
+		func() time.Duration { return timeLogin("nobody-at-all") })
+}
+
+// Timing alone can miss a login that skips the hash when it is cheap, so
+// count the password checks too: every refusal costs exactly one
+func TestLoginHashesOnce(t *testing.T) {
+	setupTiming(t)
+	for _, username := range []string{"someone", "nobody-at-all"} {
+		before := hashRuns.Load()
+		timeLogin(username)
+		if runs := hashRuns.Load() - before; runs != 1 {
+			t.Errorf("login as %q ran %d password hashes, want 1", username, runs)
+		}
+	}
+}
+
+func BenchmarkLogin(b *testing.B) {
+	setupTiming(b)
+	for _, bench := range []struct {
+		name     string
+		username string
+	}{
+		{"existing", "someone"},
+		{"missing", "nobody-at-all"},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			for b.Loop() {
+				timeLogin(bench.username)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// tracer is used for all spans created by this app. Until initTracing runs
+// (or with tracing disabled) it is backed by a no-op provider.
+var tracer = otel.Tracer("gin-webapp-with-login-and-enhanced-security")
+
+// initTracing installs the tracer provider and W3C traceparent propagation.
+// The provider is flushed and shut down by a shutdown hook.
+func initTracing(ctx context.Context) error {
+	// Always accept and forward traceparent, even when not exporting
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch config.Tracing.Exporter {
+	case "none":
+		return nil
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "otlp":
+		opts := []otlptracehttp.Option{}
+		// Without an endpoint the exporter falls back to OTEL_EXPORTER_OTLP_* variables
+		if config.Tracing.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(config.Tracing.Endpoint))
+		}
+		if config.Tracing.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	}
+	if err != nil {
+		return fmt.Errorf("creating %s trace exporter: %w", config.Tracing.Exporter, err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", config.Tracing.ServiceName),
+	))
+	if err != nil {
+		return fmt.Errorf("creating trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.Tracing.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	hooks.OnShutdown("tracer provider", provider.Shutdown)
+
+	logger.Info("Tracing enabled", "exporter", config.Tracing.Exporter, "service_name", config.Tracing.ServiceName)
+	return nil
+}
@@ -3,11 +3,12 @@ package main
 import (
 	"fmt"
 	"html"
+	"net/url"
+	"strings"
 )
 
 // CSS constant containing all dark mode styles
 const CSS = `
-<style>
 * {
 	margin: 0;
 	padding: 0;
@@ -78,11 +79,21 @@ a:hover { text-decoration: underline; }
 	margin-bottom: 1.5rem;
 	border-left: 4px solid #ff4757;
 }
-</style>
+.inline-form { display: inline; }
+.container.wide { max-width: 900px; }
+table { width: 100%; border-collapse: collapse; margin-bottom: 1.5rem; text-align: left; }
+th, td { padding: 0.5rem; border-bottom: 1px solid #555; }
+.btn.small { padding: 0.25rem 0.75rem; font-size: 0.85rem; }
 `
 
+// Style tag carrying the request's CSP nonce, since inline styles without
+// one are blocked by the Content-Security-Policy
+func styleTag(nonce string) string {
+	return fmt.Sprintf(`<style nonce="%s">%s</style>`, html.EscapeString(nonce), CSS)
+}
+
 // Homepage function
-func homepage() string {
+func homepage(nonce string) string {
 	return fmt.Sprintf(`<!DOCTYPE html>
 <html lang="en">
 <head>
@@ -98,11 +109,32 @@ func homepage() string {
 		<a href="/protected-page" class="btn">Dashboard</a>
 	</div>
 </body>
-</html>`, CSS)
+</html>`, styleTag(nonce))
+}
+
+// Flash message box shown above the login and register forms
+func flashBox(flash *Flash) string {
+	if flash == nil || flash.Message == "" {
+		return ""
+	}
+
+	class := "info-box"
+	if flash.Kind == "error" {
+		class = "error-box"
+	}
+	return fmt.Sprintf(`<div class="%s" role="alert">%s</div>`, class, html.EscapeString(flash.Message))
+}
+
+// Username previously entered in the form, if any
+func flashUsername(flash *Flash) string {
+	if flash == nil {
+		return ""
+	}
+	return html.EscapeString(flash.Username)
 }
 
 // Login function
-func loginPage() string {
+func loginPage(nonce string, flash *Flash) string {
 	return fmt.Sprintf(`<!DOCTYPE html>
 <html lang="en">
 <head>
@@ -114,8 +146,9 @@ func loginPage() string {
 <body>
 	<div class="container">
 		<h1>Login</h1>
+		%s
 		<form class="form" method="POST" action="/login">
-			<input type="text" name="username" placeholder="Username" required>
+			<input type="text" name="username" placeholder="Username" value="%s" required>
 			<input type="password" name="password" placeholder="Password" required>
 			<button type="submit" class="btn">Login</button>
 		</form>
@@ -123,11 +156,11 @@ func loginPage() string {
 		<a href="/" class="btn">Back to Homepage</a>
 	</div>
 </body>
-</html>`, CSS)
+</html>`, styleTag(nonce), flashBox(flash), flashUsername(flash))
 }
 
 // Register function
-func registerPage() string {
+func registerPage(nonce string, flash *Flash) string {
 	return fmt.Sprintf(`<!DOCTYPE html>
 <html lang="en">
 <head>
@@ -139,8 +172,9 @@ func registerPage() string {
 <body>
 	<div class="container">
 		<h1>Sign Up</h1>
+		%s
 		<form class="form" method="POST" action="/register">
-			<input type="text" name="username" placeholder="Username" required>
+			<input type="text" name="username" placeholder="Username" value="%s" required>
 			<input type="password" name="password" placeholder="Password" required>
 			<button type="submit" class="btn success">Sign Up</button>
 		</form>
@@ -148,14 +182,22 @@ func registerPage() string {
 		<a href="/" class="btn">Back to Homepage</a>
 	</div>
 </body>
-</html>`, CSS)
+</html>`, styleTag(nonce), flashBox(flash), flashUsername(flash))
 }
 
 // Protected function (Dashboard)
-func protectedPage(username string, csrfToken string) string {
+func protectedPage(nonce string, user *User) string {
 	// Escape HTML to prevent XSS attacks
-	escapedUsername := html.EscapeString(username)
-	escapedCSRFToken := html.EscapeString(csrfToken)
+	escapedUsername := html.EscapeString(user.Username)
+	escapedCSRFToken := html.EscapeString(user.CSRFToken)
+	accessLevel := "User"
+	adminLink := ""
+	if user.Role == RoleAdmin {
+		accessLevel = "Admin"
+	}
+	if user.can(PermUsersRead) {
+		adminLink = `<a href="/admin" class="btn">Admin Console</a>`
+	}
 	
 	return fmt.Sprintf(`<!DOCTYPE html>
 <html lang="en">
@@ -172,16 +214,129 @@ func protectedPage(username string, csrfToken string) string {
 		<div class="info-box">
 			<h3>User Information</h3>
 			<p>Username: %s</p>
-			<p>Access Level: User</p>
+			<p>Access Level: %s</p>
 			<p>Last Login: Just now</p>
 		</div>
-		<form method="POST" action="/logout" style="display: inline;">
+		%s
+		<a href="/change-password" class="btn">Change Password</a>
+		<form method="POST" action="/logout" class="inline-form">
 			<input type="hidden" name="csrf_token" value="%s">
 			<button type="submit" class="btn error">Logout</button>
 		</form>
 		<a href="/" class="btn">Back to Homepage</a>
 	</div>
 </body>
-</html>`, CSS, escapedUsername, escapedUsername, escapedCSRFToken)
+</html>`, styleTag(nonce), escapedUsername, escapedUsername, accessLevel, adminLink, escapedCSRFToken)
+}
+
+// Change password function. forced is set when an admin required the change.
+func changePasswordPage(nonce string, csrfToken string, forced bool, flash *Flash) string {
+	notice := ""
+	if forced {
+		notice = `<div class="info-box">An administrator has asked you to choose a new password before continuing.</div>`
+	}
+	return fmt.Sprintf(`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="UTF-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1.0">
+	<title>Change Password</title>
+	%s
+</head>
+<body>
+	<div class="container">
+		<h1>Change Password</h1>
+		%s
+		%s
+		<form class="form" method="POST" action="/change-password">
+			<input type="hidden" name="csrf_token" value="%s">
+			<input type="password" name="current_password" placeholder="Current password" autocomplete="current-password" required>
+			<input type="password" name="new_password" placeholder="New password" autocomplete="new-password" required>
+			<input type="password" name="confirm_password" placeholder="Confirm new password" autocomplete="new-password" required>
+			<button type="submit" class="btn success">Change Password</button>
+		</form>
+		<a href="/protected-page" class="btn">Back to Dashboard</a>
+	</div>
+</body>
+</html>`, styleTag(nonce), notice, flashBox(flash), html.EscapeString(csrfToken))
+}
+
+// Button posting one admin action for a user
+func adminActionButton(username, action, label, class, csrfToken string) string {
+	return fmt.Sprintf(`<form method="POST" action="/admin/users/%s/%s" class="inline-form">
+				<input type="hidden" name="csrf_token" value="%s">
+				<button type="submit" class="btn small %s">%s</button>
+			</form>`, url.PathEscape(username), action, html.EscapeString(csrfToken), class, label)
+}
+
+// Admin console function listing every user with the actions for each
+func adminPage(nonce string, admin *User, users []User, flash *Flash) string {
+	var rows strings.Builder
+	for _, user := range users {
+		status := "Active"
+		if user.Locked {
+			status = "Locked"
+		} else if user.SessionToken != "" {
+			status = "Signed in"
+		}
+		if user.MustChangePassword {
+			status += ", reset pending"
+		}
+
+		lockAction := adminActionButton(user.Username, "lock", "Lock", "error", admin.CSRFToken)
+		if user.Locked {
+			lockAction = adminActionButton(user.Username, "unlock", "Unlock", "success", admin.CSRFToken)
+		}
+		fmt.Fprintf(&rows, `
+		<tr>
+			<td>%s</td>
+			<td>%s</td>
+			<td>%s</td>
+			<td>%s %s %s</td>
+		</tr>`, html.EscapeString(user.Username), html.EscapeString(string(roleOrDefault(user.Role))), status,
+			lockAction,
+			adminActionButton(user.Username, "reset-password", "Force reset", "", admin.CSRFToken),
+			adminActionButton(user.Username, "revoke-sessions", "Sign out", "", admin.CSRFToken))
+	}
+
+	return fmt.Sprintf(`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="UTF-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1.0">
+	<title>Admin</title>
+	%s
+</head>
+<body>
+	<div class="container wide">
+		<h1>User Management</h1>
+		%s
+		<table>
+			<tr><th>Username</th><th>Role</th><th>Status</th><th>Actions</th></tr>%s
+		</table>
+		<a href="/admin/audit" class="btn">Audit Log</a>
+		<a href="/protected-page" class="btn">Back to Dashboard</a>
+	</div>
+</body>
+</html>`, styleTag(nonce), flashBox(flash), rows.String())
 }
 
+// Widget function (embeddable in other sites)
+func widgetPage(nonce string) string {
+	return fmt.Sprintf(`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="UTF-8">
+	<meta name="viewport" content="width=device-width, initial-scale=1.0">
+	<title>Widget</title>
+	%s
+</head>
+<body>
+	<div class="container">
+		<h3>Secure Web Application</h3>
+		<p>Sign in to access your dashboard.</p>
+		<a href="/login" target="_top" class="btn">Login</a>
+	</div>
+</body>
+</html>`, styleTag(nonce))
+}
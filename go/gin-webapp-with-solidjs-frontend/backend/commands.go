@@ -0,0 +1,12 @@
+package main
+
+import (
+	"shared/cli"
+	"shared/server"
+)
+
+// commands are maintenance tasks run as `go run . <name> [flags]` instead of
+// starting the server
+var commands = cli.Commands{
+	"devcert": {Summary: "write a self-signed certificate for local HTTPS testing", Run: server.RunDevCert},
+}
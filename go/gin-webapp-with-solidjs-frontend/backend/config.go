@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"shared/settings"
+)
+
+// Config holds every tunable setting of the server. Values are resolved in
+// order of precedence: defaults, YAML file, environment variables, CLI flags.
+type Config struct {
+	Server ServerConfig `yaml:"server"`
+	CORS   CORSConfig   `yaml:"cors"`
+	JWT    JWTConfig    `yaml:"jwt"`
+	TLS    TLSConfig    `yaml:"tls"`
+}
+
+type ServerConfig struct {
+	Addr            string        `yaml:"addr"`
+	GinMode         string        `yaml:"gin_mode"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	DrainDelay      time.Duration `yaml:"drain_delay"`
+}
+
+// TLSConfig enables HTTPS when both files are set. The pair is reloaded
+// whenever either file changes. RedirectAddr optionally starts a plain HTTP
+// listener that redirects everything to the TLS listener on Server.Addr.
+type TLSConfig struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	RedirectAddr string `yaml:"redirect_addr"`
+}
+
+// Enabled reports whether the server should serve HTTPS
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != ""
+}
+
+type CORSConfig struct {
+	AllowOrigins []string `yaml:"allow_origins"`
+}
+
+type JWTConfig struct {
+	Secret string        `yaml:"secret"`
+	TTL    time.Duration `yaml:"ttl"`
+}
+
+// defaultJWTSecret is only good enough for local development; validate
+// refuses it outside debug mode
+const defaultJWTSecret = "my_secret_key"
+
+// config is the active configuration, set once at startup
+var config = defaultConfig()
+
+func defaultConfig() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:            ":8080",
+			GinMode:         gin.DebugMode,
+			ShutdownTimeout: 25 * time.Second,
+			// Long enough for two failed readiness checks at Fly's 5s interval
+			DrainDelay: 10 * time.Second,
+		},
+		CORS: CORSConfig{
+			AllowOrigins: []string{"http://localhost:3000"},
+		},
+		JWT: JWTConfig{
+			Secret: defaultJWTSecret,
+			TTL:    5 * time.Minute,
+		},
+	}
+}
+
+func (cfg *Config) settings() []settings.Setting {
+	return []settings.Setting{
+		settings.Port("PORT", "port", "listen port, shorthand for -addr :PORT", &cfg.Server.Addr),
+		settings.String("APP_ADDR", "addr", "listen address", &cfg.Server.Addr),
+		settings.String("APP_GIN_MODE", "gin-mode", "gin mode (debug, release or test)", &cfg.Server.GinMode),
+		settings.Duration("APP_SHUTDOWN_TIMEOUT", "shutdown-timeout", "how long shutdown may take, draining and hooks included", &cfg.Server.ShutdownTimeout),
+		settings.Duration("APP_DRAIN_DELAY", "drain-delay", "how long /readyz fails before listeners close on shutdown", &cfg.Server.DrainDelay),
+		settings.String("APP_TLS_CERT", "tls-cert", "TLS certificate file (enables HTTPS)", &cfg.TLS.CertFile),
+		settings.String("APP_TLS_KEY", "tls-key", "TLS private key file", &cfg.TLS.KeyFile),
+		settings.String("APP_TLS_REDIRECT_ADDR", "tls-redirect-addr", "plain HTTP address that redirects to HTTPS", &cfg.TLS.RedirectAddr),
+		settings.List("APP_CORS_ORIGINS", "cors-origins", "comma separated origins allowed by CORS", &cfg.CORS.AllowOrigins),
+		settings.String("APP_JWT_SECRET", "jwt-secret", "HMAC key used to sign JWTs", &cfg.JWT.Secret),
+		settings.Duration("APP_JWT_TTL", "jwt-ttl", "lifetime of issued JWTs", &cfg.JWT.TTL),
+	}
+}
+
+// loadConfig builds the configuration from defaults, the YAML file given by
+// -config or APP_CONFIG, environment variables and finally CLI flags
+func loadConfig(args []string) (*Config, error) {
+	cfg := defaultConfig()
+	if err := settings.Load(args, cfg, cfg.settings()); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// validate reports every invalid setting at once
+func (cfg *Config) validate() error {
+	var errs []error
+
+	if _, _, err := net.SplitHostPort(cfg.Server.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("server.addr %q must be host:port or :port", cfg.Server.Addr))
+	}
+	switch cfg.Server.GinMode {
+	case gin.DebugMode, gin.ReleaseMode, gin.TestMode:
+	default:
+		errs = append(errs, fmt.Errorf("server.gin_mode %q must be debug, release or test", cfg.Server.GinMode))
+	}
+	if cfg.Server.ShutdownTimeout < time.Second {
+		errs = append(errs, fmt.Errorf("server.shutdown_timeout %s must be at least 1s", cfg.Server.ShutdownTimeout))
+	}
+	// The delay comes out of shutdown_timeout, so leave most of it for draining
+	if cfg.Server.DrainDelay < 0 || cfg.Server.DrainDelay >= cfg.Server.ShutdownTimeout/2 {
+		errs = append(errs, fmt.Errorf("server.drain_delay %s must be between 0 and half of server.shutdown_timeout", cfg.Server.DrainDelay))
+	}
+	if cfg.TLS.Enabled() && (cfg.TLS.CertFile == "" || cfg.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("tls.cert_file and tls.key_file must be set together"))
+	}
+	if cfg.TLS.RedirectAddr != "" {
+		if !cfg.TLS.Enabled() {
+			errs = append(errs, errors.New("tls.redirect_addr requires tls.cert_file and tls.key_file"))
+		} else if _, _, err := net.SplitHostPort(cfg.TLS.RedirectAddr); err != nil {
+			errs = append(errs, fmt.Errorf("tls.redirect_addr %q must be host:port or :port", cfg.TLS.RedirectAddr))
+		}
+	}
+
+	for _, origin := range cfg.CORS.AllowOrigins {
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			errs = append(errs, fmt.Errorf("cors.allow_origins entry %q must be an http(s) origin like https://example.com", origin))
+		}
+	}
+
+	if cfg.JWT.Secret == "" {
+		errs = append(errs, errors.New("jwt.secret must not be empty"))
+	} else if cfg.JWT.Secret == defaultJWTSecret && cfg.Server.GinMode != gin.DebugMode {
+		errs = append(errs, errors.New("jwt.secret must be changed from the built-in default outside debug mode (set APP_JWT_SECRET)"))
+	}
+	if cfg.JWT.TTL < time.Second {
+		errs = append(errs, fmt.Errorf("jwt.ttl %s must be at least 1s", cfg.JWT.TTL))
+	}
+
+	return errors.Join(errs...)
+}
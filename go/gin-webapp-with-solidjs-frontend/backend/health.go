@@ -0,0 +1,20 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"shared/server"
+)
+
+// health answers /healthz and /readyz
+var health = server.NewHealth()
+
+func registerReadinessChecks() {
+	health.AddCheck("frontend", func() error {
+		if _, err := os.Stat(staticIndex); err != nil {
+			return fmt.Errorf("frontend build missing: %w", err)
+		}
+		return nil
+	})
+}
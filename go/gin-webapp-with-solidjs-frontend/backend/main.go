@@ -1,7 +1,12 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 
@@ -10,74 +15,78 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var jwtKey = []byte("my_secret_key")
-var tokens []string
+var jwtKey []byte
+
+// Built SolidJS app, copied into ./static by the Dockerfile
+const staticIndex = "./static/index.html"
 
 type Claims struct {
-	Username string `json:"username"`
+	Username    string   `json:"username"`
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
 	jwt.RegisteredClaims
 }
 
+// accountRoles assigns a role to each Basic Auth account
+var accountRoles = map[string]string{
+	"admin": RoleAdmin,
+}
+
 func main() {
-	r := gin.Default()
+	if handled, err := commands.Run(os.Args[1:]); handled {
+		if err != nil && !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	// Uncomment when deploying to release mode
-	// gin.SetMode(gin.ReleaseMode)
+	cfg, err := loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	config = cfg
+	jwtKey = []byte(config.JWT.Secret)
+
+	// Set APP_GIN_MODE=release when deploying
+	gin.SetMode(config.Server.GinMode)
+
+	r := gin.Default()
 
 	// Enable CORS for development
 	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:3000"},
+		AllowOrigins:     config.CORS.AllowOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 	}))
 
-	err := r.SetTrustedProxies(nil)
+	err = r.SetTrustedProxies(nil)
 	if err != nil {
 		panic(err)
 	}
 
+	// Liveness and readiness probes for Fly.io and operators
+	r.GET("/healthz", gin.WrapF(health.Live))
+	r.GET("/readyz", gin.WrapF(health.Ready))
+
 	r.Static("/assets", "./static/assets")
-	r.StaticFile("/", "./static/index.html")
+	r.StaticFile("/", staticIndex)
 
 	r.POST("/login", gin.BasicAuth(gin.Accounts{
 		"admin": "secret",
 	}), func(c *gin.Context) {
-		token, _ := generateJWT()
-		tokens = append(tokens, token)
-
+		token, _ := generateJWT(c.GetString(gin.AuthUserKey))
 		c.JSON(http.StatusOK, gin.H{
 			"token": token,
 		})
 	})
 
-	r.GET("/resource", func(c *gin.Context) {
-		bearerToken := c.Request.Header.Get("Authorization")
-		reqToken := strings.Split(bearerToken, " ")[1]
-		claims := &Claims{}
-		tkn, err := jwt.ParseWithClaims(reqToken, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtKey, nil
-		})
-		if err != nil {
-			if err == jwt.ErrSignatureInvalid {
-				c.JSON(http.StatusUnauthorized, gin.H{
-					"message": "unauthorized",
-				})
-				return
-			}
-			c.JSON(http.StatusBadRequest, gin.H{
-				"message": "bad request",
-			})
-			return
-		}
-		if !tkn.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"message": "unauthorized",
-			})
-			return
-		}
-
+	r.GET("/resource", requireJWT(), requirePermission(PermResourceRead), func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			"data": "resource data",
 		})
@@ -85,17 +94,27 @@ func main() {
 
 	r.NoRoute(func(c *gin.Context) {
 		if !strings.HasPrefix(c.Request.URL.Path, "/assets/") {
-			c.File("./static/index.html")
+			c.File(staticIndex)
 		}
 	})
 
-	r.Run()
+	registerReadinessChecks()
+
+	if err := runServer(r); err != nil {
+		log.Fatal(err)
+	}
 }
 
-func generateJWT() (string, error) {
-	expirationTime := time.Now().Add(5 * time.Minute)
+func generateJWT(username string) (string, error) {
+	expirationTime := time.Now().Add(config.JWT.TTL)
+	role, ok := accountRoles[username]
+	if !ok {
+		role = RoleUser
+	}
 	claims := &Claims{
-		Username: "username",
+		Username:    username,
+		Role:        role,
+		Permissions: rolePermissions[role],
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 		},
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"shared/grants"
+)
+
+// Permissions are "resource:action", optionally followed by ":instance" to
+// scope them to one resource. In grants "*" matches any part, "self" as the
+// instance matches the token's own username, and a grant without an
+// instance covers every instance.
+const (
+	PermResourceRead = "resource:read"
+)
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// rolePermissions maps each role to the permissions written into its tokens
+var rolePermissions = map[string][]string{
+	RoleUser:  {PermResourceRead},
+	RoleAdmin: {"*:*"},
+}
+
+// can reports whether the token's claims grant the permission
+func (c *Claims) can(permission string) bool {
+	for _, grant := range c.Permissions {
+		if grants.Covers(grant, permission, c.Username) {
+			return true
+		}
+	}
+	return false
+}
+
+// requireJWT validates the bearer token and stores its claims in the context
+func requireJWT() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reqToken, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"message": "unauthorized",
+			})
+			return
+		}
+
+		claims := &Claims{}
+		tkn, err := jwt.ParseWithClaims(reqToken, claims, func(token *jwt.Token) (interface{}, error) {
+			return jwtKey, nil
+		}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+		if err != nil || !tkn.Valid {
+			if err != nil && !errors.Is(err, jwt.ErrTokenMalformed) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+					"message": "unauthorized",
+				})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"message": "bad request",
+			})
+			return
+		}
+
+		c.Set("claims", claims)
+		c.Next()
+	}
+}
+
+// requirePermission must run after requireJWT. Permissions are taken from
+// the token, so they change when the user next logs in.
+func requirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		checkPermission(c, permission)
+	}
+}
+
+func checkPermission(c *gin.Context, permission string) {
+	claims, ok := c.Get("claims")
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"message": "unauthorized",
+		})
+		return
+	}
+	if !claims.(*Claims).can(permission) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"message": "forbidden",
+		})
+		return
+	}
+	c.Next()
+}
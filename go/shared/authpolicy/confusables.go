@@ -0,0 +1,70 @@
+package authpolicy
+
+import (
+	"slices"
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// confusables maps characters to the Latin letter they are easily mistaken
+// for. It is the part of the Unicode confusables table (UTS #39) that
+// matters for usernames: Cyrillic and Greek lookalikes, digits and symbols
+// that pass for letters. Fullwidth and other compatibility forms are
+// already folded by NFKC.
+var confusables = map[rune]string{
+	// Cyrillic
+	'а': "a", 'с': "c", 'ԁ': "d", 'е': "e", 'һ': "h", 'і': "i", 'ј': "j",
+	'ӏ': "l", 'о': "o", 'р': "p", 'ԛ': "q", 'г': "r", 'ѕ': "s", 'ԝ': "w",
+	'х': "x", 'у': "y", 'ү': "y", 'ь': "b",
+	'А': "A", 'В': "B", 'Е': "E", 'Н': "H", 'І': "l", 'Ј': "J", 'К': "K",
+	'Ӏ': "l", 'М': "M", 'О': "O", 'Р': "P", 'Ѕ': "S", 'Т': "T", 'Х': "X",
+	'У': "Y", 'С': "C", 'Ԝ': "W", 'Ү': "Y",
+	// Greek
+	'Α': "A", 'Β': "B", 'Ε': "E", 'Ζ': "Z", 'Η': "H", 'Ι': "l", 'Κ': "K",
+	'Μ': "M", 'Ν': "N", 'Ο': "O", 'Ρ': "P", 'Τ': "T", 'Υ': "Y", 'Χ': "X",
+	'α': "a", 'ι': "i", 'ν': "v", 'ο': "o", 'ρ': "p", 'υ': "u",
+	// Latin and Common
+	'ɡ': "g", 'ı': "i", 'ɩ': "i", 'ʏ': "y", 'ꞵ': "b",
+	'0': "O", '1': "l", 'I': "l", '|': "l", 'ǀ': "l",
+	'‐': "-", '‑': "-", '‒': "-", '–': "-", '—': "-", '−': "-", '⁃': "-",
+}
+
+// skeleton reduces a string to the form two strings that look the same
+// share, like "paypal" and "pаypаl" with Cyrillic a's. Case is folded after
+// mapping, so that I and l, or 0 and o, collide too.
+func skeleton(s string) string {
+	var mapped strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		if target, ok := confusables[r]; ok {
+			mapped.WriteString(target)
+		} else {
+			mapped.WriteRune(r)
+		}
+	}
+	return norm.NFD.String(cases.Fold().String(mapped.String()))
+}
+
+// Skeletons returns the skeletons of the name as written and
+// case-folded; names that share one look alike. Both are needed because
+// mapping first turns a capital I into an l that no longer matches a
+// lowercase i, as in ADMIN and admin.
+func Skeletons(username string) []string {
+	asWritten := skeleton(username)
+	folded := skeleton(cases.Fold().String(username))
+	if folded == asWritten {
+		return []string{asWritten}
+	}
+	return []string{asWritten, folded}
+}
+
+// LooksAlike reports whether two usernames share a skeleton
+func LooksAlike(a, b string) bool {
+	for _, sa := range Skeletons(a) {
+		if slices.Contains(Skeletons(b), sa) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,249 @@
+// Package authpolicy holds the rules for new usernames and passwords:
+// lengths, character classes, Unicode normalization, reserved names,
+// lookalike names and denied passwords.
+package authpolicy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/secure/precis"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Character classes a password policy can require, with how they read in
+// error messages
+var characterClasses = map[string]struct {
+	describe string
+	match    func(rune) bool
+}{
+	"upper":  {"uppercase letter", unicode.IsUpper},
+	"lower":  {"lowercase letter", unicode.IsLower},
+	"digit":  {"number", unicode.IsDigit},
+	"symbol": {"symbol", func(r rune) bool { return unicode.IsPunct(r) || unicode.IsSymbol(r) }},
+}
+
+// Config holds the rules for new usernames and passwords. It is also served
+// as JSON so clients can show the rules up front. Compile must be called
+// before it is used.
+type Config struct {
+	Username UsernamePolicy `yaml:"username" json:"username"`
+	Password PasswordPolicy `yaml:"password" json:"password"`
+}
+
+type UsernamePolicy struct {
+	MinLength int `yaml:"min_length" json:"min_length"`
+	// MaxLength of 0 means no limit
+	MaxLength int `yaml:"max_length" json:"max_length,omitempty"`
+	// Pattern must match the whole username; PatternHint completes
+	// "username can only contain ..." when it doesn't
+	Pattern     string `yaml:"pattern" json:"pattern,omitempty"`
+	PatternHint string `yaml:"pattern_hint" json:"pattern_hint,omitempty"`
+	// Normalize is the Unicode normalization applied first: none, nfc,
+	// nfkc, or precis for the RFC 8265 UsernameCasePreserved profile
+	Normalize string `yaml:"normalize" json:"normalize"`
+	// Reserved names (and lookalikes) can't be registered, only created
+	// with create-admin
+	Reserved []string `yaml:"reserved" json:"reserved,omitempty"`
+	// RejectConfusable refuses names that look like an existing account
+	RejectConfusable bool `yaml:"reject_confusable" json:"reject_confusable"`
+
+	pattern *regexp.Regexp
+}
+
+type PasswordPolicy struct {
+	MinLength int `yaml:"min_length" json:"min_length"`
+	MaxLength int `yaml:"max_length" json:"max_length,omitempty"`
+	// Require lists character classes: upper, lower, digit or symbol
+	Require   []string `yaml:"require" json:"require,omitempty"`
+	Normalize string   `yaml:"normalize" json:"normalize"`
+	// Deny and DenyFile (one password per line) are refused regardless of
+	// case. They aren't published.
+	Deny           []string `yaml:"deny" json:"-"`
+	DenyFile       string   `yaml:"deny_file" json:"-"`
+	RejectUsername bool     `yaml:"reject_username" json:"reject_username"`
+
+	denied map[string]struct{}
+}
+
+func normalize(form, s string) string {
+	switch form {
+	case "nfc":
+		return norm.NFC.String(s)
+	case "nfkc":
+		return norm.NFKC.String(s)
+	}
+	return s
+}
+
+// Compile checks the policy, compiles the username pattern and loads the
+// deny file. Errors name fields as they appear under policy in the config
+// file.
+func (p *Config) Compile() error {
+	var errs []error
+
+	switch p.Username.Normalize {
+	case "none", "nfc", "nfkc", "precis":
+	default:
+		errs = append(errs, fmt.Errorf("policy.username.normalize %q must be none, nfc, nfkc or precis", p.Username.Normalize))
+	}
+	switch p.Password.Normalize {
+	case "none", "nfc", "nfkc":
+	default:
+		errs = append(errs, fmt.Errorf("policy.password.normalize %q must be none, nfc or nfkc", p.Password.Normalize))
+	}
+	if p.Username.MinLength < 1 || (p.Username.MaxLength != 0 && p.Username.MaxLength < p.Username.MinLength) {
+		errs = append(errs, fmt.Errorf("policy.username lengths %d-%d must be at least 1 and in order", p.Username.MinLength, p.Username.MaxLength))
+	}
+	if p.Password.MinLength < 1 || (p.Password.MaxLength != 0 && p.Password.MaxLength < p.Password.MinLength) {
+		errs = append(errs, fmt.Errorf("policy.password lengths %d-%d must be at least 1 and in order", p.Password.MinLength, p.Password.MaxLength))
+	}
+	p.Username.pattern = nil
+	if p.Username.Pattern != "" {
+		pattern, err := regexp.Compile(`^(?:` + p.Username.Pattern + `)$`)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("policy.username.pattern: %w", err))
+		}
+		p.Username.pattern = pattern
+	}
+	for _, class := range p.Password.Require {
+		if _, ok := characterClasses[class]; !ok {
+			errs = append(errs, fmt.Errorf("policy.password.require %q must be upper, lower, digit or symbol", class))
+		}
+	}
+
+	if p.Password.DenyFile != "" {
+		if err := p.Password.loadDenyFile(); err != nil {
+			errs = append(errs, fmt.Errorf("policy.password.deny_file: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (p *PasswordPolicy) loadDenyFile() error {
+	file, err := os.Open(p.DenyFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	p.denied = make(map[string]struct{})
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			p.denied[strings.ToLower(line)] = struct{}{}
+		}
+	}
+	return scanner.Err()
+}
+
+// Canonical is the form usernames are stored and shown in. Case is kept;
+// see Key for comparing names.
+func (p *UsernamePolicy) Canonical(username string) string {
+	username = strings.TrimSpace(username)
+	if p.Normalize == "precis" {
+		if enforced, err := precis.UsernameCasePreserved.String(username); err == nil {
+			return enforced
+		}
+		// Left as is for Validate to reject
+		return username
+	}
+	return normalize(p.Normalize, username)
+}
+
+// Key is the case-folded form accounts are unique by, so Alice and alice
+// are the same user
+func (p *UsernamePolicy) Key(username string) string {
+	username = p.Canonical(username)
+	if p.Normalize == "precis" {
+		if key, err := precis.UsernameCaseMapped.String(username); err == nil {
+			return key
+		}
+	}
+	return norm.NFKC.String(cases.Fold().String(username))
+}
+
+// Validate checks a canonical username; reserved names are checked apart,
+// as create-admin may use them
+func (p *UsernamePolicy) Validate(username string) error {
+	if p.Normalize == "precis" {
+		if _, err := precis.UsernameCasePreserved.String(username); err != nil {
+			return errors.New("username contains characters that aren't allowed")
+		}
+	}
+	length := utf8.RuneCountInString(username)
+	if length < p.MinLength {
+		return fmt.Errorf("username must be at least %d characters long", p.MinLength)
+	}
+	if p.MaxLength != 0 && length > p.MaxLength {
+		return fmt.Errorf("username must be no more than %d characters long", p.MaxLength)
+	}
+	if p.pattern != nil && !p.pattern.MatchString(username) {
+		if p.PatternHint != "" {
+			return errors.New("username can only contain " + p.PatternHint)
+		}
+		return errors.New("username contains characters that aren't allowed")
+	}
+	return nil
+}
+
+// IsReserved also matches names that merely look reserved, like "аdmin"
+// with a Cyrillic a
+func (p *UsernamePolicy) IsReserved(username string) bool {
+	return slices.ContainsFunc(p.Reserved, func(r string) bool { return LooksAlike(r, username) })
+}
+
+// Canonical is the form passwords are hashed in
+func (p *PasswordPolicy) Canonical(password string) string {
+	return normalize(p.Normalize, password)
+}
+
+// Validate checks a new password for the given canonical username
+func (p *PasswordPolicy) Validate(username, password string) error {
+	password = p.Canonical(password)
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters long", p.MinLength)
+	}
+	if p.MaxLength != 0 && length > p.MaxLength {
+		return fmt.Errorf("password must be no more than %d characters long", p.MaxLength)
+	}
+
+	for _, class := range p.Require {
+		if !strings.ContainsFunc(password, characterClasses[class].match) {
+			var wanted []string
+			for _, class := range p.Require {
+				wanted = append(wanted, "one "+characterClasses[class].describe)
+			}
+			return errors.New("password must contain at least " + joinWords(wanted))
+		}
+	}
+
+	lower := strings.ToLower(password)
+	if _, denied := p.denied[lower]; denied || slices.ContainsFunc(p.Deny, func(d string) bool { return strings.EqualFold(d, password) }) {
+		return errors.New("password is too common, please choose another")
+	}
+	if p.RejectUsername && username != "" && strings.Contains(lower, strings.ToLower(username)) {
+		return errors.New("password must not contain the username")
+	}
+	return nil
+}
+
+// joinWords lists items as "a", "a and b" or "a, b, and c"
+func joinWords(items []string) string {
+	switch len(items) {
+	case 1:
+		return items[0]
+	case 2:
+		return items[0] + " and " + items[1]
+	}
+	return strings.Join(items[:len(items)-1], ", ") + ", and " + items[len(items)-1]
+}
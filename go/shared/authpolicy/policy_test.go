@@ -0,0 +1,134 @@
+package authpolicy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testPolicy returns a compiled policy like the apps' defaults
+func testPolicy(t *testing.T) *Config {
+	t.Helper()
+	p := &Config{
+		Username: UsernamePolicy{
+			MinLength:        6,
+			Normalize:        "nfkc",
+			Reserved:         []string{"admin", "root"},
+			RejectConfusable: true,
+		},
+		Password: PasswordPolicy{
+			MinLength:      16,
+			Normalize:      "nfkc",
+			RejectUsername: true,
+		},
+	}
+	if err := p.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	policy := &testPolicy(t).Password
+	policy.Require = []string{"upper", "digit"}
+	policy.Deny = []string{"Correct-Horse-Battery-9"}
+
+	tests := []struct {
+		password string
+		wantErr  string
+	}{
+		{"Short1", "at least 16 characters"},
+		{"all-lowercase-and-long", "one uppercase letter and one number"},
+		{"CORRECT-horse-battery-9", "too common"},
+		{"Mr-Someone-Else-2024", "must not contain the username"},
+		{"Tangerine-Ceiling-42", ""},
+	}
+	for _, tt := range tests {
+		err := policy.Validate("someone", tt.password)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%q: unexpected error %v", tt.password, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%q: got %v, want an error containing %q", tt.password, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPolicyDenyFile(t *testing.T) {
+	p := testPolicy(t)
+	file := filepath.Join(t.TempDir(), "deny.txt")
+	os.WriteFile(file, []byte("first-denied-password\n\nSecond-Denied-Password\n"), 0o600)
+	p.Password.DenyFile = file
+	if err := p.Compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Password.Validate("someone", "SECOND-denied-password"); err == nil {
+		t.Error("password from the deny file was accepted")
+	}
+
+	p.Password.DenyFile = filepath.Join(t.TempDir(), "missing.txt")
+	if err := p.Compile(); err == nil {
+		t.Error("a missing deny file was not reported")
+	}
+}
+
+func TestCompileRejectsBadSettings(t *testing.T) {
+	p := testPolicy(t)
+	p.Username.Normalize = "nfd"
+	p.Password.Require = []string{"emoji"}
+	p.Username.Pattern = "("
+
+	err := p.Compile()
+	if err == nil {
+		t.Fatal("a broken policy compiled")
+	}
+	for _, field := range []string{"policy.username.normalize", "policy.password.require", "policy.username.pattern"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("%v doesn't name %s", err, field)
+		}
+	}
+}
+
+func TestLooksAlike(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		want bool
+	}{
+		{"paypal-support", "pаypаl-support", true}, // Cyrillic а
+		{"paypal-support", "PAYPAL-SUPPORT", true},
+		{"paypal-support", "paypa1-support", true},
+		{"paypal-support", "pаypal–support", true}, // en dash
+		{"Alice", "AIice", true},
+		{"alice", "alison", false},
+	} {
+		if got := LooksAlike(tt.a, tt.b); got != tt.want {
+			t.Errorf("LooksAlike(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestReservedLookalikes(t *testing.T) {
+	policy := &testPolicy(t).Username
+	for _, name := range []string{"ADMIN", "аdmin", "AdmIn"} {
+		if !policy.IsReserved(name) {
+			t.Errorf("%q is not treated as reserved", name)
+		}
+	}
+}
+
+func TestPrecisUsernames(t *testing.T) {
+	policy := &testPolicy(t).Username
+	policy.Normalize = "precis"
+
+	if got := policy.Canonical(" Zoë "); got != "Zoë" {
+		t.Errorf("Canonical kept case as %q, want %q", got, "Zoë")
+	}
+	if policy.Key("ZOË") != policy.Key("zoë") {
+		t.Error("keys differ by case")
+	}
+	if err := policy.Validate(policy.Canonical("two words")); err == nil {
+		t.Error("a username with a space passed the PRECIS profile")
+	}
+}
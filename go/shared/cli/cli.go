@@ -0,0 +1,49 @@
+// Package cli dispatches maintenance commands, run as
+// `go run . <name> [flags]` instead of starting the server
+package cli
+
+import (
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Command is one maintenance task
+type Command struct {
+	Summary string
+	Run     func(args []string) error
+}
+
+// Commands maps each command name to its task
+type Commands map[string]Command
+
+// Run runs the command named by args[0]. It reports false when args don't
+// name a command, so the caller can start the server instead.
+func (c Commands) Run(args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+	if args[0] == "help" {
+		c.Print()
+		return true, nil
+	}
+	cmd, ok := c[args[0]]
+	if !ok {
+		return false, nil
+	}
+	return true, cmd.Run(args[1:])
+}
+
+// Print lists the commands on stderr
+func (c Commands) Print() {
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "Commands (run without one to start the server):")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, c[name].Summary)
+	}
+}
@@ -0,0 +1,31 @@
+package cli
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestCommandsRun(t *testing.T) {
+	var got []string
+	failed := errors.New("failed")
+	commands := Commands{
+		"greet": {"say hello", func(args []string) error {
+			got = args
+			return failed
+		}},
+	}
+
+	if handled, err := commands.Run([]string{"greet", "-name", "you"}); !handled || err != failed {
+		t.Errorf("greet: got handled %v, error %v; want true and the command's error", handled, err)
+	}
+	if want := []string{"-name", "you"}; !slices.Equal(got, want) {
+		t.Errorf("command got args %v, want %v", got, want)
+	}
+
+	for _, args := range [][]string{nil, {"-addr", ":8080"}, {"unknown"}} {
+		if handled, err := commands.Run(args); handled || err != nil {
+			t.Errorf("%q: got handled %v, error %v; want the server to start", args, handled, err)
+		}
+	}
+}
@@ -0,0 +1,11 @@
+module shared
+
+go 1.24.4
+
+require (
+	golang.org/x/crypto v0.40.0
+	golang.org/x/text v0.28.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/sys v0.34.0 // indirect
@@ -0,0 +1,34 @@
+// Package grants matches permission grants. Permissions are
+// "resource:action", optionally followed by ":instance" to scope them to one
+// resource. In grants "*" matches any part, "self" as the instance matches
+// the caller's own name, and a grant without an instance covers every
+// instance.
+package grants
+
+import "strings"
+
+// Covers reports whether grant includes the wanted permission for the user
+// named self
+func Covers(grant, want, self string) bool {
+	g := strings.Split(grant, ":")
+	w := strings.Split(want, ":")
+	if len(g) < 2 || len(w) < 2 {
+		return false
+	}
+	for i := range 2 {
+		if g[i] != "*" && g[i] != w[i] {
+			return false
+		}
+	}
+	switch {
+	case len(g) == 2:
+		return true
+	case len(w) == 2:
+		// A scoped grant doesn't give the unscoped permission
+		return false
+	case g[2] == "*" || g[2] == w[2]:
+		return true
+	default:
+		return g[2] == "self" && w[2] == self
+	}
+}
@@ -0,0 +1,44 @@
+package grants
+
+import "testing"
+
+func TestCovers(t *testing.T) {
+	for _, test := range []struct {
+		grant, want, self string
+		covers            bool
+	}{
+		{"users:read", "users:read", "alice", true},
+		{"users:read", "users:manage", "alice", false},
+		{"users:read", "audit:read", "alice", false},
+
+		// Wildcards
+		{"*:*", "users:manage", "alice", true},
+		{"*:*", "users:manage:bob", "alice", true},
+		{"users:*", "users:manage", "alice", true},
+		{"*:read", "audit:read", "alice", true},
+		{"*:read", "audit:write", "alice", false},
+		{"users:manage:*", "users:manage:bob", "alice", true},
+
+		// Unscoped grants cover every instance, scoped ones only their own
+		{"users:manage", "users:manage:bob", "alice", true},
+		{"users:manage:bob", "users:manage:bob", "alice", true},
+		{"users:manage:bob", "users:manage:carol", "alice", false},
+		{"users:manage:bob", "users:manage", "alice", false},
+		{"users:manage:*", "users:manage", "alice", false},
+
+		// self matches the caller only
+		{"users:read:self", "users:read:alice", "alice", true},
+		{"users:read:self", "users:read:bob", "alice", false},
+		{"users:read:self", "users:read", "alice", false},
+		{"users:read:self", "users:read:self", "self", true},
+
+		// Malformed permissions never match
+		{"users", "users:read", "alice", false},
+		{"*:*", "users", "alice", false},
+		{"", "", "", false},
+	} {
+		if got := Covers(test.grant, test.want, test.self); got != test.covers {
+			t.Errorf("Covers(%q, %q, %q) = %v, want %v", test.grant, test.want, test.self, got, test.covers)
+		}
+	}
+}
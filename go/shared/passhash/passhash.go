@@ -0,0 +1,299 @@
+// Package passhash hashes and verifies passwords with argon2id or bcrypt.
+// Hashes are encoded with their algorithm and parameters, so a stored hash
+// always verifies after the configured algorithm changes, and Verify says
+// when it should be replaced.
+package passhash
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcrypt only looks at the first 72 bytes of a password
+const bcryptMaxPasswordBytes = 72
+
+var (
+	ErrPasswordTooLong = errors.New("password is too long for bcrypt")
+	errUnknownHash     = errors.New("unrecognised password hash format")
+)
+
+// Config picks the algorithm new password hashes use, "argon2id" or
+// "bcrypt", and bounds how many run at once
+type Config struct {
+	Algorithm  string       `yaml:"algorithm"`
+	BcryptCost int          `yaml:"bcrypt_cost"`
+	Argon2     Argon2Config `yaml:"argon2"`
+	Pool       PoolConfig   `yaml:"pool"`
+}
+
+type Argon2Config struct {
+	MemoryKiB   int `yaml:"memory_kib"`
+	Iterations  int `yaml:"iterations"`
+	Parallelism int `yaml:"parallelism"`
+	SaltLength  int `yaml:"salt_length"`
+	KeyLength   int `yaml:"key_length"`
+}
+
+// PoolConfig bounds the CPU spent on password hashing. Workers hashes run
+// at once; up to Queue more requests wait at most QueueTimeout for one to
+// finish, and the rest are told to come back after RetryAfter.
+type PoolConfig struct {
+	Workers      int           `yaml:"workers"`
+	Queue        int           `yaml:"queue"`
+	QueueTimeout time.Duration `yaml:"queue_timeout"`
+	RetryAfter   time.Duration `yaml:"retry_after"`
+}
+
+// DefaultConfig is argon2id with the OWASP recommended parameters and one
+// hashing worker per CPU
+func DefaultConfig() Config {
+	return Config{
+		Algorithm:  "argon2id",
+		BcryptCost: bcrypt.DefaultCost,
+		Argon2: Argon2Config{
+			MemoryKiB:   19 * 1024,
+			Iterations:  2,
+			Parallelism: 1,
+			SaltLength:  16,
+			KeyLength:   32,
+		},
+		Pool: PoolConfig{
+			Workers:      runtime.NumCPU(),
+			Queue:        64,
+			QueueTimeout: 5 * time.Second,
+			RetryAfter:   time.Second,
+		},
+	}
+}
+
+// Validate checks every setting. Errors name fields as they appear under
+// password in the config file.
+func (c *Config) Validate() error {
+	var errs []error
+
+	switch c.Algorithm {
+	case "argon2id", "bcrypt":
+	default:
+		errs = append(errs, fmt.Errorf("password.algorithm %q must be argon2id or bcrypt", c.Algorithm))
+	}
+	if c.BcryptCost < bcrypt.MinCost || c.BcryptCost > bcrypt.MaxCost {
+		errs = append(errs, fmt.Errorf("password.bcrypt_cost %d must be between %d and %d", c.BcryptCost, bcrypt.MinCost, bcrypt.MaxCost))
+	}
+	argon := c.Argon2
+	if argon.Parallelism < 1 || argon.Parallelism > 255 {
+		errs = append(errs, fmt.Errorf("password.argon2.parallelism %d must be between 1 and 255", argon.Parallelism))
+	}
+	if argon.MemoryKiB < 8*argon.Parallelism || argon.MemoryKiB > 4*1024*1024 {
+		errs = append(errs, fmt.Errorf("password.argon2.memory_kib %d must be between 8 x parallelism and 4194304", argon.MemoryKiB))
+	}
+	if argon.Iterations < 1 || argon.Iterations > 100 {
+		errs = append(errs, fmt.Errorf("password.argon2.iterations %d must be between 1 and 100", argon.Iterations))
+	}
+	if argon.SaltLength < 16 || argon.SaltLength > 64 {
+		errs = append(errs, fmt.Errorf("password.argon2.salt_length %d must be between 16 and 64", argon.SaltLength))
+	}
+	if argon.KeyLength < 16 || argon.KeyLength > 64 {
+		errs = append(errs, fmt.Errorf("password.argon2.key_length %d must be between 16 and 64", argon.KeyLength))
+	}
+
+	pool := c.Pool
+	if pool.Workers < 1 {
+		errs = append(errs, fmt.Errorf("password.pool.workers %d must be at least 1", pool.Workers))
+	}
+	if pool.Queue < 0 {
+		errs = append(errs, fmt.Errorf("password.pool.queue %d must not be negative", pool.Queue))
+	}
+	if pool.QueueTimeout < 0 {
+		errs = append(errs, fmt.Errorf("password.pool.queue_timeout %s must not be negative", pool.QueueTimeout))
+	}
+	if pool.RetryAfter < time.Second {
+		errs = append(errs, fmt.Errorf("password.pool.retry_after %s must be at least 1s", pool.RetryAfter))
+	}
+	return errors.Join(errs...)
+}
+
+// Hasher is one password hashing algorithm with its parameters
+type Hasher interface {
+	Hash(password string) (string, error)
+	Verify(encoded, password string) (bool, error)
+	// Owns reports whether encoded was produced by this algorithm
+	Owns(encoded string) bool
+	// Outdated reports whether encoded uses different parameters
+	Outdated(encoded string) bool
+}
+
+// Hasher returns the hasher new passwords are hashed with
+func (c *Config) Hasher() Hasher {
+	if c.Algorithm == "bcrypt" {
+		return bcryptHasher{cost: c.BcryptCost}
+	}
+	return argon2idHasher{
+		memory:      uint32(c.Argon2.MemoryKiB),
+		iterations:  uint32(c.Argon2.Iterations),
+		parallelism: uint8(c.Argon2.Parallelism),
+		saltLength:  c.Argon2.SaltLength,
+		keyLength:   uint32(c.Argon2.KeyLength),
+	}
+}
+
+// knownHashers can verify every hash format a store may contain
+var knownHashers = []Hasher{bcryptHasher{}, argon2idHasher{}}
+
+// Verify checks password against a hash from any supported algorithm.
+// needsRehash is set when it matched but the hash was made with another
+// algorithm or other parameters than current, so the caller can store a
+// fresh hash while it has the plaintext.
+func Verify(current Hasher, encoded, password string) (match, needsRehash bool) {
+	for _, hasher := range append([]Hasher{current}, knownHashers...) {
+		if !hasher.Owns(encoded) {
+			continue
+		}
+		ok, err := hasher.Verify(encoded, password)
+		if err != nil || !ok {
+			return false, false
+		}
+		return true, !current.Owns(encoded) || current.Outdated(encoded)
+	}
+	return false, false
+}
+
+// Dummy is a hash of a random password nobody knows. Verifying against it
+// when a username doesn't exist makes the reply take as long as for a real
+// account.
+type Dummy struct {
+	mu     sync.Mutex
+	hasher Hasher
+	hash   string
+}
+
+// Hash returns the dummy hash made with hasher, hashing a new one whenever
+// the hasher changes so it always costs the same as a real one
+func (d *Dummy) Hash(hasher Hasher) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.hash == "" || d.hasher != hasher {
+		hash, err := hasher.Hash(rand.Text())
+		if err != nil {
+			return "", err
+		}
+		d.hasher, d.hash = hasher, hash
+	}
+	return d.hash, nil
+}
+
+// bcryptHasher produces modular crypt hashes such as $2a$10$...
+type bcryptHasher struct {
+	cost int
+}
+
+func (h bcryptHasher) Hash(password string) (string, error) {
+	// Refuse rather than silently ignoring everything past 72 bytes
+	if len(password) > bcryptMaxPasswordBytes {
+		return "", ErrPasswordTooLong
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	return string(hashed), err
+}
+
+func (h bcryptHasher) Verify(encoded, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h bcryptHasher) Owns(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (h bcryptHasher) Outdated(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.cost
+}
+
+// argon2idHasher produces PHC strings:
+// $argon2id$v=19$m=<KiB>,t=<iterations>,p=<threads>$<salt>$<hash>
+type argon2idHasher struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	saltLength  int
+	keyLength   uint32
+}
+
+type argon2idHash struct {
+	argon2idHasher
+	salt []byte
+	key  []byte
+}
+
+func (h argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.saltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("generating salt: %w", err)
+	}
+	key := argon2.IDKey([]byte(password), salt, h.iterations, h.memory, h.parallelism, h.keyLength)
+
+	b64 := base64.RawStdEncoding
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.memory, h.iterations, h.parallelism, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+func parseArgon2id(encoded string) (*argon2idHash, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return nil, errUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+
+	var parsed argon2idHash
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &parsed.memory, &parsed.iterations, &parsed.parallelism); err != nil {
+		return nil, fmt.Errorf("invalid argon2 parameters %q", parts[3])
+	}
+
+	var err error
+	b64 := base64.RawStdEncoding
+	if parsed.salt, err = b64.DecodeString(parts[4]); err != nil {
+		return nil, fmt.Errorf("invalid argon2 salt: %w", err)
+	}
+	if parsed.key, err = b64.DecodeString(parts[5]); err != nil {
+		return nil, fmt.Errorf("invalid argon2 hash: %w", err)
+	}
+	parsed.saltLength = len(parsed.salt)
+	parsed.keyLength = uint32(len(parsed.key))
+	return &parsed, nil
+}
+
+func (h argon2idHasher) Verify(encoded, password string) (bool, error) {
+	parsed, err := parseArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	key := argon2.IDKey([]byte(password), parsed.salt, parsed.iterations, parsed.memory, parsed.parallelism, parsed.keyLength)
+	return subtle.ConstantTimeCompare(key, parsed.key) == 1, nil
+}
+
+func (h argon2idHasher) Owns(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (h argon2idHasher) Outdated(encoded string) bool {
+	parsed, err := parseArgon2id(encoded)
+	return err != nil || parsed.argon2idHasher != h
+}
@@ -0,0 +1,100 @@
+package passhash
+
+import (
+	"strings"
+	"testing"
+)
+
+// testConfig hashes quickly enough for tests
+func testConfig() Config {
+	cfg := DefaultConfig()
+	cfg.BcryptCost = 4
+	cfg.Argon2.MemoryKiB = 64
+	cfg.Argon2.Iterations = 1
+	return cfg
+}
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	cfg := testConfig()
+	hasher := cfg.Hasher()
+	encoded, err := hasher.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("hash %q is not a PHC argon2id string with the configured parameters", encoded)
+	}
+
+	if match, rehash := Verify(hasher, encoded, "correct horse battery staple"); !match || rehash {
+		t.Errorf("correct password: match %v, rehash %v; want true, false", match, rehash)
+	}
+	if match, _ := Verify(hasher, encoded, "wrong"); match {
+		t.Error("wrong password matched")
+	}
+}
+
+func TestOutdatedHashesNeedRehash(t *testing.T) {
+	const password = "correct horse battery staple"
+	cfg := testConfig()
+	cfg.Algorithm = "bcrypt"
+	bcryptHash, _ := cfg.Hasher().Hash(password)
+	cfg.Algorithm = "argon2id"
+	weakHash, _ := cfg.Hasher().Hash(password)
+	cfg.Argon2.Iterations = 2
+
+	for name, encoded := range map[string]string{"bcrypt": bcryptHash, "old parameters": weakHash} {
+		if match, rehash := Verify(cfg.Hasher(), encoded, password); !match || !rehash {
+			t.Errorf("%s: match %v, rehash %v; want true, true", name, match, rehash)
+		}
+	}
+}
+
+func TestBcryptRejectsLongPasswords(t *testing.T) {
+	cfg := testConfig()
+	cfg.Algorithm = "bcrypt"
+	if _, err := cfg.Hasher().Hash(strings.Repeat("a", 73)); err != ErrPasswordTooLong {
+		t.Fatalf("hashing 73 bytes returned %v, want ErrPasswordTooLong", err)
+	}
+}
+
+func TestMalformedArgon2Hash(t *testing.T) {
+	cfg := testConfig()
+	for _, encoded := range []string{"$argon2id$v=19$m=64,t=1,p=1$!!!$abc", "$argon2id$v=18$m=64,t=1,p=1$YWJj$YWJj", "$argon2id$", "plaintext"} {
+		if match, _ := Verify(cfg.Hasher(), encoded, "anything"); match {
+			t.Errorf("malformed hash %q matched", encoded)
+		}
+	}
+}
+
+// The dummy hash follows the configured hasher, so unknown users cost the
+// same as real ones after a parameter change
+func TestDummyFollowsHasher(t *testing.T) {
+	cfg := testConfig()
+	var dummy Dummy
+	first, err := dummy.Hash(cfg.Hasher())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := dummy.Hash(cfg.Hasher()); again != first {
+		t.Error("dummy hash was remade for the same hasher")
+	}
+
+	cfg.Argon2.Iterations = 2
+	changed, _ := dummy.Hash(cfg.Hasher())
+	if cfg.Hasher().Outdated(changed) {
+		t.Errorf("dummy hash %q doesn't use the new parameters", changed)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := DefaultConfig()
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("defaults: %v", err)
+	}
+	cfg.Algorithm = "md5"
+	cfg.Pool.Workers = 0
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "password.algorithm") || !strings.Contains(err.Error(), "password.pool.workers") {
+		t.Errorf("got %v, want both bad settings named", err)
+	}
+}
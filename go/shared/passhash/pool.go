@@ -0,0 +1,102 @@
+package passhash
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrBusy is returned when every hashing worker is busy and the queue is
+// full, or a request waited in the queue longer than allowed
+var ErrBusy = errors.New("too many password checks in progress")
+
+// Pool bounds how many password hashes run at once. Each hash takes tens of
+// milliseconds of CPU, so without a limit a burst of logins starves every
+// other route.
+type Pool struct {
+	// slots holds a token per running hash, queue one per waiting request
+	slots        chan struct{}
+	queue        chan struct{}
+	queueTimeout time.Duration
+
+	// OnStart, if set, is called as each fn starts with how long it waited
+	// for a worker
+	OnStart func(ctx context.Context, waited time.Duration)
+	// OnReject, if set, is called for each fn that never runs, with the
+	// reason: "queue_full", "timeout" or "canceled"
+	OnReject func(reason string)
+}
+
+func NewPool(cfg PoolConfig) *Pool {
+	return &Pool{
+		slots:        make(chan struct{}, cfg.Workers),
+		queue:        make(chan struct{}, cfg.Queue),
+		queueTimeout: cfg.QueueTimeout,
+	}
+}
+
+// Workers is how many hashes may run at once, Running how many are
+func (p *Pool) Workers() int { return cap(p.slots) }
+func (p *Pool) Running() int { return len(p.slots) }
+
+// QueueLimit is how many requests may wait for a worker, Queued how many are
+func (p *Pool) QueueLimit() int { return cap(p.queue) }
+func (p *Pool) Queued() int     { return len(p.queue) }
+
+// Do runs fn once a worker is free. It fails with ErrBusy rather than wait
+// behind a full queue, and with the context's error if the request is
+// abandoned while queued.
+func (p *Pool) Do(ctx context.Context, fn func()) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	start := time.Now()
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		if err := p.wait(ctx); err != nil {
+			return err
+		}
+	}
+	defer func() { <-p.slots }()
+	if p.OnStart != nil {
+		p.OnStart(ctx, time.Since(start))
+	}
+
+	fn()
+	return nil
+}
+
+// wait queues for a worker slot
+func (p *Pool) wait(ctx context.Context) error {
+	select {
+	case p.queue <- struct{}{}:
+	default:
+		p.rejected("queue_full")
+		return ErrBusy
+	}
+	defer func() { <-p.queue }()
+
+	var timeout <-chan time.Time
+	if p.queueTimeout > 0 {
+		timer := time.NewTimer(p.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case p.slots <- struct{}{}:
+		return nil
+	case <-timeout:
+		p.rejected("timeout")
+		return ErrBusy
+	case <-ctx.Done():
+		p.rejected("canceled")
+		return ctx.Err()
+	}
+}
+
+func (p *Pool) rejected(reason string) {
+	if p.OnReject != nil {
+		p.OnReject(reason)
+	}
+}
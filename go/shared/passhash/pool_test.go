@@ -0,0 +1,107 @@
+package passhash
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// occupy holds every worker of the pool until the returned func is called
+func occupy(pool *Pool) (release func()) {
+	done := make(chan struct{})
+	for range pool.Workers() {
+		started := make(chan struct{})
+		go pool.Do(context.Background(), func() {
+			close(started)
+			<-done
+		})
+		<-started
+	}
+	return func() { close(done) }
+}
+
+// recordRejects collects the reasons passed to OnReject
+func recordRejects(pool *Pool) func() []string {
+	var mu sync.Mutex
+	var reasons []string
+	pool.OnReject = func(reason string) {
+		mu.Lock()
+		defer mu.Unlock()
+		reasons = append(reasons, reason)
+	}
+	return func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(reasons)
+	}
+}
+
+func TestPoolRejectsWhenQueueIsFull(t *testing.T) {
+	pool := NewPool(PoolConfig{Workers: 1, Queue: 1, QueueTimeout: time.Minute})
+	rejects := recordRejects(pool)
+	release := occupy(pool)
+
+	queued := make(chan error)
+	go func() { queued <- pool.Do(context.Background(), func() {}) }()
+	for pool.Queued() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := pool.Do(context.Background(), func() {}); err != ErrBusy {
+		t.Errorf("with the queue full: got %v, want ErrBusy", err)
+	}
+	release()
+	if err := <-queued; err != nil {
+		t.Errorf("queued request: %v", err)
+	}
+	if got := rejects(); !slices.Equal(got, []string{"queue_full"}) {
+		t.Errorf("got rejections %v, want [queue_full]", got)
+	}
+}
+
+func TestPoolGivesUpWaiting(t *testing.T) {
+	pool := NewPool(PoolConfig{Workers: 1, Queue: 1, QueueTimeout: 10 * time.Millisecond})
+	rejects := recordRejects(pool)
+	release := occupy(pool)
+	defer release()
+
+	if err := pool.Do(context.Background(), func() {}); err != ErrBusy {
+		t.Errorf("after the queue timeout: got %v, want ErrBusy", err)
+	}
+
+	pool.queueTimeout = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	ran := false
+	if err := pool.Do(ctx, func() { ran = true }); err != context.DeadlineExceeded || ran {
+		t.Errorf("abandoned request: got %v (ran %v), want DeadlineExceeded", err, ran)
+	}
+	if got := rejects(); !slices.Equal(got, []string{"timeout", "canceled"}) {
+		t.Errorf("got rejections %v, want [timeout canceled]", got)
+	}
+}
+
+func TestPoolReportsWaits(t *testing.T) {
+	pool := NewPool(PoolConfig{Workers: 1, Queue: 1})
+	var waits []time.Duration
+	pool.OnStart = func(_ context.Context, waited time.Duration) { waits = append(waits, waited) }
+
+	release := occupy(pool)
+	done := make(chan error)
+	go func() { done <- pool.Do(context.Background(), func() {}) }()
+	for pool.Queued() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	release()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// The first wait is the worker taken by occupy
+	if len(waits) != 2 || waits[1] < 10*time.Millisecond {
+		t.Errorf("got waits %v, want the queued request to have waited 10ms", waits)
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Health answers liveness and readiness probes. Readiness fails once
+// shutdown begins, so the load balancer stops sending new requests, or
+// while any registered check fails.
+type Health struct {
+	started  time.Time
+	draining atomic.Bool
+
+	mu     sync.Mutex
+	checks []readinessCheck
+}
+
+// readinessCheck is one dependency that must be usable before traffic is
+// accepted
+type readinessCheck struct {
+	name  string
+	check func() error
+}
+
+func NewHealth() *Health {
+	return &Health{started: time.Now()}
+}
+
+// AddCheck registers a readiness check. Checks run on every probe, so they
+// must be quick.
+func (h *Health) AddCheck(name string, check func() error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checks = append(h.checks, readinessCheck{name: name, check: check})
+}
+
+// StartDraining makes readiness fail from now on
+func (h *Health) StartDraining() {
+	h.draining.Store(true)
+}
+
+func (h *Health) uptime() string {
+	return time.Since(h.started).Round(time.Second).String()
+}
+
+// Live reports that the process is alive and serving requests
+func (h *Health) Live(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status": "ok",
+		"uptime": h.uptime(),
+	})
+}
+
+// Ready reports whether this instance should receive traffic
+func (h *Health) Ready(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	checks := append([]readinessCheck(nil), h.checks...)
+	h.mu.Unlock()
+
+	ready := !h.draining.Load()
+	results := make(map[string]any, len(checks))
+	for _, rc := range checks {
+		start := time.Now()
+		err := rc.check()
+		result := map[string]string{"status": "ok", "duration": time.Since(start).String()}
+		if err != nil {
+			ready = false
+			result["status"] = "failing"
+			result["error"] = err.Error()
+		}
+		results[rc.name] = result
+	}
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "not ready", http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]any{
+		"status":   status,
+		"draining": h.draining.Load(),
+		"uptime":   h.uptime(),
+		"checks":   results,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
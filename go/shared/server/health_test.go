@@ -0,0 +1,51 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// probe returns the status code and decoded body of a readiness probe
+func probe(t *testing.T, h *Health) (int, map[string]any) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.Ready(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var body map[string]any
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code, body
+}
+
+func TestReadiness(t *testing.T) {
+	h := NewHealth()
+	var broken error
+	h.AddCheck("database", func() error { return broken })
+
+	if code, body := probe(t, h); code != http.StatusOK || body["status"] != "ready" {
+		t.Errorf("got %d %v, want ready", code, body)
+	}
+
+	broken = errors.New("connection refused")
+	code, body := probe(t, h)
+	check := body["checks"].(map[string]any)["database"].(map[string]any)
+	if code != http.StatusServiceUnavailable || check["status"] != "failing" || check["error"] != "connection refused" {
+		t.Errorf("got %d %v, want the failing check reported", code, body)
+	}
+
+	broken = nil
+	h.StartDraining()
+	if code, body := probe(t, h); code != http.StatusServiceUnavailable || body["draining"] != true {
+		t.Errorf("got %d %v, want not ready while draining", code, body)
+	}
+
+	// Liveness doesn't depend on either
+	rec := httptest.NewRecorder()
+	h.Live(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("liveness got %d while draining, want 200", rec.Code)
+	}
+}
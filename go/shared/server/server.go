@@ -0,0 +1,214 @@
+// Package server runs an app's HTTP listeners until SIGINT or SIGTERM and
+// then shuts down gracefully: readiness fails first, in-flight requests
+// drain, and the registered shutdown hooks run, all within one deadline.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// hook is run after the server stops accepting requests and has drained,
+// e.g. to stop background goroutines or persist in-memory state
+type hook struct {
+	name string
+	fn   func(context.Context) error
+}
+
+// Hooks collects the work to do on shutdown. The zero value is ready to use.
+type Hooks struct {
+	mu    sync.Mutex
+	hooks []hook
+}
+
+// OnShutdown registers a hook. Hooks run in reverse order of registration,
+// so something registered early (like saving state) runs after the
+// goroutines that modify that state have been stopped.
+func (h *Hooks) OnShutdown(name string, fn func(context.Context) error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hooks = append(h.hooks, hook{name: name, fn: fn})
+}
+
+// StartBackground runs fn every interval until the server shuts down
+func (h *Hooks) StartBackground(name string, interval time.Duration, fn func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	}()
+
+	h.OnShutdown(name, func(shutdownCtx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+			return nil
+		case <-shutdownCtx.Done():
+			return shutdownCtx.Err()
+		}
+	})
+}
+
+func (h *Hooks) run(ctx context.Context, logger *slog.Logger) error {
+	h.mu.Lock()
+	hooks := append([]hook(nil), h.hooks...)
+	h.mu.Unlock()
+
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if err := hooks[i].fn(ctx); err != nil {
+			logger.Error("Shutdown hook failed", "hook", hooks[i].name, "error", err.Error())
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Options describes how Run serves and shuts down
+type Options struct {
+	Addr string
+	// ShutdownTimeout bounds the whole shutdown, drain delay, draining and
+	// hooks included. Keep it below the platform's kill timeout.
+	ShutdownTimeout time.Duration
+	// DrainDelay is how long failing readiness has to take effect (a load
+	// balancer noticing the failed check) before the listeners close
+	DrainDelay time.Duration
+	// Health, if set, starts draining as soon as shutdown begins and gets a
+	// check that fails once the TLS certificate has expired
+	Health *Health
+
+	// CertFile and KeyFile, if set, make Addr serve HTTPS. The pair is
+	// reloaded when either file changes. RedirectAddr then optionally
+	// listens for plain HTTP and redirects it to Addr.
+	CertFile     string
+	KeyFile      string
+	RedirectAddr string
+
+	Hooks  *Hooks
+	Logger *slog.Logger
+}
+
+// Run serves handler until SIGINT, SIGTERM or a listener fails, then stops
+// accepting new connections, waits up to the drain deadline for in-flight
+// requests and runs the shutdown hooks
+func Run(handler http.Handler, opts Options) error {
+	logger := opts.Logger
+	srv := &http.Server{
+		Addr:              opts.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Room for both the main and the redirect listener to report an error
+	serveErr := make(chan error, 2)
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		reloader, err := newCertReloader(opts.CertFile, opts.KeyFile, logger)
+		if err != nil {
+			return err
+		}
+		opts.Hooks.StartBackground("certificate reloader", certReloadInterval, reloader.reloadIfChanged)
+		if opts.Health != nil {
+			opts.Health.AddCheck("tls certificate", reloader.check)
+		}
+		srv.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.GetCertificate,
+		}
+
+		if opts.RedirectAddr != "" {
+			startRedirectServer(opts, serveErr)
+		}
+		go func() {
+			serveErr <- srv.ListenAndServeTLS("", "")
+		}()
+	} else {
+		go func() {
+			serveErr <- srv.ListenAndServe()
+		}()
+	}
+
+	// A listener that fails goes through the same shutdown as a signal, so
+	// in-flight requests still drain and the hooks still save state
+	var errs []error
+	select {
+	case err := <-serveErr:
+		logger.Error("Listener failed, shutting down", "error", err.Error())
+		errs = append(errs, err)
+	case <-ctx.Done():
+		// A second signal kills the process straight away
+		stop()
+	}
+
+	// The drain delay, draining and the hooks all share ShutdownTimeout.
+	// Draining stops a fifth of the way short so the hooks always get time
+	// to save state.
+	deadline := time.Now().Add(opts.ShutdownTimeout)
+	hookCtx, cancelHooks := context.WithDeadline(context.Background(), deadline)
+	defer cancelHooks()
+	drainDeadline := deadline.Add(-opts.ShutdownTimeout / 5)
+	drainCtx, cancel := context.WithDeadline(hookCtx, drainDeadline)
+	defer cancel()
+
+	// Fail readiness first and give the load balancer a moment to notice
+	// before the listeners close
+	if opts.Health != nil {
+		opts.Health.StartDraining()
+	}
+	if opts.DrainDelay > 0 {
+		time.Sleep(opts.DrainDelay)
+	}
+
+	logger.Info("Shutting down, draining connections", "timeout", time.Until(drainDeadline).Round(time.Second).String())
+
+	if err := srv.Shutdown(drainCtx); err != nil {
+		logger.Error("Connections did not drain in time", "error", err.Error())
+		errs = append(errs, err)
+	}
+
+	if err := opts.Hooks.run(hookCtx, logger); err != nil {
+		errs = append(errs, err)
+	}
+
+	logger.Info("Server stopped")
+	return errors.Join(errs...)
+}
+
+// startRedirectServer listens on opts.RedirectAddr and redirects every
+// request to HTTPS. It is closed by a shutdown hook; a failure to listen is
+// sent to serveErr.
+func startRedirectServer(opts Options, serveErr chan<- error) {
+	redirect := &http.Server{
+		Addr:              opts.RedirectAddr,
+		Handler:           httpsRedirectHandler(opts.Addr),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := redirect.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			serveErr <- err
+		}
+	}()
+	opts.Hooks.OnShutdown("https redirect listener", redirect.Shutdown)
+	opts.Logger.Info("Redirecting plain HTTP to HTTPS", "addr", opts.RedirectAddr)
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHooksRunInReverse(t *testing.T) {
+	var hooks Hooks
+	var order []string
+	failed := errors.New("disk full")
+	for _, name := range []string{"save state", "close log", "stop janitor"} {
+		hooks.OnShutdown(name, func(context.Context) error {
+			order = append(order, name)
+			if name == "close log" {
+				return failed
+			}
+			return nil
+		})
+	}
+
+	err := hooks.run(context.Background(), slog.New(slog.DiscardHandler))
+	if want := []string{"stop janitor", "close log", "save state"}; !slices.Equal(order, want) {
+		t.Errorf("hooks ran in order %v, want %v", order, want)
+	}
+	if !errors.Is(err, failed) {
+		t.Errorf("got %v, want the failing hook's error", err)
+	}
+}
+
+func TestStartBackgroundStopsOnShutdown(t *testing.T) {
+	var hooks Hooks
+	var ticks atomic.Int64
+	hooks.StartBackground("ticker", time.Millisecond, func() { ticks.Add(1) })
+
+	deadline := time.Now().Add(time.Second)
+	for ticks.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := hooks.run(context.Background(), slog.New(slog.DiscardHandler)); err != nil {
+		t.Fatal(err)
+	}
+	stopped := ticks.Load()
+	time.Sleep(10 * time.Millisecond)
+	if stopped == 0 || ticks.Load() != stopped {
+		t.Errorf("ticked %d times before shutdown and %d after, want some before and none after", stopped, ticks.Load()-stopped)
+	}
+}
+
+// A listener that can't start shuts down like a signal would, so the hooks
+// still run
+func TestRunShutsDownWhenListenerFails(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	var hooks Hooks
+	var saved bool
+	health := NewHealth()
+	hooks.OnShutdown("save state", func(context.Context) error {
+		saved = true
+		return nil
+	})
+	err = Run(http.NotFoundHandler(), Options{
+		Addr:            taken.Addr().String(),
+		ShutdownTimeout: time.Second,
+		Health:          health,
+		Hooks:           &hooks,
+		Logger:          slog.New(slog.DiscardHandler),
+	})
+	if err == nil {
+		t.Error("got no error for an address already in use")
+	}
+	if !health.draining.Load() || !saved {
+		t.Errorf("draining %v, hooks run %v; want both", health.draining.Load(), saved)
+	}
+}
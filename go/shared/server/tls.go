@@ -0,0 +1,127 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// How often the certificate files are checked for changes
+const certReloadInterval = 30 * time.Second
+
+// certReloader serves the certificate from disk and picks up a renewed
+// cert/key pair without a restart
+type certReloader struct {
+	certFile string
+	keyFile  string
+	logger   *slog.Logger
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string, logger *slog.Logger) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile, logger: logger}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// latestModTime returns the newer modification time of the two files
+func (r *certReloader) latestModTime() (time.Time, error) {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime(), nil
+	}
+	return certInfo.ModTime(), nil
+}
+
+func (r *certReloader) load() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return fmt.Errorf("reading TLS certificate: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading TLS certificate: %w", err)
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.mu.Unlock()
+	return nil
+}
+
+// reloadIfChanged reloads the pair when either file has been modified. A
+// broken pair (e.g. cert written but key not yet) keeps the old certificate.
+func (r *certReloader) reloadIfChanged() {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		r.logger.Warn("TLS certificate check failed", "error", err.Error())
+		return
+	}
+
+	r.mu.RLock()
+	unchanged := modTime.Equal(r.modTime)
+	r.mu.RUnlock()
+	if unchanged {
+		return
+	}
+
+	if err := r.load(); err != nil {
+		r.logger.Warn("TLS certificate reload failed, keeping previous certificate", "error", err.Error())
+		return
+	}
+	r.logger.Info("TLS certificate reloaded", "cert_file", r.certFile)
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// check reports an error when no certificate is loaded or it has expired
+func (r *certReloader) check() error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.cert == nil || r.cert.Leaf == nil {
+		return errors.New("no TLS certificate loaded")
+	}
+	if time.Now().After(r.cert.Leaf.NotAfter) {
+		return fmt.Errorf("TLS certificate expired at %s", r.cert.Leaf.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// httpsRedirectHandler sends plain HTTP requests to the TLS listener on
+// tlsAddr
+func httpsRedirectHandler(tlsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
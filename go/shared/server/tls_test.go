@@ -0,0 +1,71 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCertReloaderPicksUpNewPair(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := RunDevCert([]string{"-cert", certFile, "-key", keyFile}); err != nil {
+		t.Fatal(err)
+	}
+	reloader, err := newCertReloader(certFile, keyFile, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reloader.check(); err != nil {
+		t.Fatalf("fresh certificate failed its check: %v", err)
+	}
+
+	// An already expired pair replaces it once the files change
+	if err := RunDevCert([]string{"-cert", certFile, "-key", keyFile, "-valid-for", "-1m"}); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(certFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+	reloader.reloadIfChanged()
+	if err := reloader.check(); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("got %v, want the reloaded certificate reported as expired", err)
+	}
+
+	// A broken pair keeps the certificate already loaded
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later = later.Add(time.Minute)
+	if err := os.Chtimes(keyFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+	reloader.reloadIfChanged()
+	if cert, _ := reloader.GetCertificate(nil); cert == nil {
+		t.Error("a broken pair dropped the loaded certificate")
+	}
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	for _, tc := range []struct {
+		tlsAddr, host, want string
+	}{
+		{":443", "example.com", "https://example.com/login?next=%2F"},
+		{":443", "example.com:80", "https://example.com/login?next=%2F"},
+		{":8443", "localhost:8080", "https://localhost:8443/login?next=%2F"},
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/login?next=%2F", nil)
+		req.Host = tc.host
+		httpsRedirectHandler(tc.tlsAddr).ServeHTTP(rec, req)
+		if got := rec.Header().Get("Location"); rec.Code != http.StatusMovedPermanently || got != tc.want {
+			t.Errorf("%s via %s: got %d to %q, want 301 to %q", tc.host, tc.tlsAddr, rec.Code, got, tc.want)
+		}
+	}
+}
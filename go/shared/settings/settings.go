@@ -0,0 +1,161 @@
+// Package settings loads a server's configuration in layers. Values are
+// resolved in order of precedence: defaults, YAML file, environment
+// variables, CLI flags.
+package settings
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Setting binds one config field to its environment variable and CLI flag
+type Setting struct {
+	env    string
+	flag   string
+	usage  string
+	isBool bool
+	set    func(string) error
+}
+
+func String(env, flagName, usage string, dst *string) Setting {
+	return Setting{env: env, flag: flagName, usage: usage, set: func(v string) error {
+		*dst = v
+		return nil
+	}}
+}
+
+func Int(env, flagName, usage string, dst *int) Setting {
+	return Setting{env: env, flag: flagName, usage: usage, set: func(v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", v)
+		}
+		*dst = n
+		return nil
+	}}
+}
+
+func Float(env, flagName, usage string, dst *float64) Setting {
+	return Setting{env: env, flag: flagName, usage: usage, set: func(v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", v)
+		}
+		*dst = f
+		return nil
+	}}
+}
+
+func Duration(env, flagName, usage string, dst *time.Duration) Setting {
+	return Setting{env: env, flag: flagName, usage: usage, set: func(v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration (e.g. 24h, 30m)", v)
+		}
+		*dst = d
+		return nil
+	}}
+}
+
+func Bool(env, flagName, usage string, dst *bool) Setting {
+	return Setting{env: env, flag: flagName, usage: usage, isBool: true, set: func(v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", v)
+		}
+		*dst = b
+		return nil
+	}}
+}
+
+// List splits a comma separated value, dropping empty items
+func List(env, flagName, usage string, dst *[]string) Setting {
+	return Setting{env: env, flag: flagName, usage: usage, set: func(v string) error {
+		var items []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*dst = items
+		return nil
+	}}
+}
+
+// Port accepts a bare port number, as set by Fly.io in $PORT, and stores it
+// as the listen address :PORT
+func Port(env, flagName, usage string, dst *string) Setting {
+	return Setting{env: env, flag: flagName, usage: usage, set: func(v string) error {
+		if _, err := strconv.Atoi(v); err != nil {
+			return fmt.Errorf("%q is not a port number", v)
+		}
+		*dst = ":" + v
+		return nil
+	}}
+}
+
+// flagValue records a flag's raw value so it can be applied after the
+// config file and environment, giving flags the highest precedence
+type flagValue struct {
+	value  string
+	isBool bool
+}
+
+func (f *flagValue) String() string     { return f.value }
+func (f *flagValue) Set(v string) error { f.value = v; return nil }
+func (f *flagValue) IsBoolFlag() bool   { return f.isBool }
+
+// Load fills cfg, which already holds the defaults, from the YAML file given
+// by -config or APP_CONFIG, then environment variables and finally CLI
+// flags. Validating the result is left to the caller.
+func Load(args []string, cfg any, settings []Setting) error {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("APP_CONFIG"), "path to a YAML config file")
+	flags := make(map[string]*flagValue, len(settings))
+	for _, s := range settings {
+		flags[s.flag] = &flagValue{isBool: s.isBool}
+		fs.Var(flags[s.flag], s.flag, s.usage+" (env "+s.env+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *configPath != "" {
+		data, err := os.ReadFile(*configPath)
+		if err != nil {
+			return fmt.Errorf("reading config file: %w", err)
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil {
+			return fmt.Errorf("parsing config file %s: %w", *configPath, err)
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.env); ok {
+			if err := s.set(value); err != nil {
+				return fmt.Errorf("environment variable %s: %w", s.env, err)
+			}
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && flagErr == nil {
+				if err := s.set(flags[s.flag].value); err != nil {
+					flagErr = fmt.Errorf("flag -%s: %w", s.flag, err)
+				}
+			}
+		}
+	})
+	return flagErr
+}
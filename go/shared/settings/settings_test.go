@@ -0,0 +1,57 @@
+package settings
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Addr    string        `yaml:"addr"`
+	Timeout time.Duration `yaml:"timeout"`
+	Debug   bool          `yaml:"debug"`
+	Origins []string      `yaml:"origins"`
+}
+
+func (cfg *testConfig) settings() []Setting {
+	return []Setting{
+		Port("TEST_PORT", "port", "listen port", &cfg.Addr),
+		String("TEST_ADDR", "addr", "listen address", &cfg.Addr),
+		Duration("TEST_TIMEOUT", "timeout", "timeout", &cfg.Timeout),
+		Bool("TEST_DEBUG", "debug", "debug mode", &cfg.Debug),
+		List("TEST_ORIGINS", "origins", "origins", &cfg.Origins),
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(file, []byte("addr: :1000\ntimeout: 1s\norigins: [a]\n"), 0o600)
+	t.Setenv("APP_CONFIG", file)
+	t.Setenv("TEST_TIMEOUT", "2s")
+	t.Setenv("TEST_ORIGINS", " b, ,c ")
+
+	cfg := &testConfig{Addr: ":80", Timeout: time.Second}
+	if err := Load([]string{"-port", "3000", "-debug"}, cfg, cfg.settings()); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":3000" || cfg.Timeout != 2*time.Second || !cfg.Debug {
+		t.Errorf("got %+v, want flags over env over file", cfg)
+	}
+	if len(cfg.Origins) != 2 || cfg.Origins[0] != "b" || cfg.Origins[1] != "c" {
+		t.Errorf("got origins %q, want [b c]", cfg.Origins)
+	}
+}
+
+func TestLoadReportsBadValues(t *testing.T) {
+	cfg := &testConfig{}
+	if err := Load([]string{"-timeout", "soon"}, cfg, cfg.settings()); err == nil {
+		t.Error("an invalid duration flag was accepted")
+	}
+
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(file, []byte("unknown: 1\n"), 0o600)
+	if err := Load([]string{"-config", file}, cfg, cfg.settings()); err == nil {
+		t.Error("an unknown config file key was accepted")
+	}
+}
@@ -0,0 +1,39 @@
+package timingstats
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"shared/passhash"
+)
+
+// AssertParity times n runs of each case and fails t if they take
+// measurably different times. It is skipped in -short mode.
+func AssertParity(t testing.TB, n int, a, b func() time.Duration) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("timing comparison is slow")
+	}
+	got := Compare(Interleave(n, a, b))
+	t.Logf("compared %d runs each: %v", n, got)
+	if got.Differs() {
+		t.Errorf("the second case answers in %v, the first in %v", got.MeanB, got.MeanA)
+	}
+}
+
+// CountStarts counts the jobs pool starts from now on, keeping any OnStart
+// hook it already has. Timing alone can miss a path that skips the hash
+// when hashing is cheap, so tests also check the count.
+func CountStarts(pool *passhash.Pool) *atomic.Int64 {
+	var runs atomic.Int64
+	onStart := pool.OnStart
+	pool.OnStart = func(ctx context.Context, waited time.Duration) {
+		runs.Add(1)
+		if onStart != nil {
+			onStart(ctx, waited)
+		}
+	}
+	return &runs
+}
@@ -0,0 +1,94 @@
+// Package timingstats compares two sets of response times, for tests that
+// check timing doesn't reveal which of two cases a request hit. Samples are
+// interleaved so drift in machine load affects both alike, and Welch's
+// t-test decides whether the means differ by more than noise.
+package timingstats
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"time"
+)
+
+// Significant is the t statistic above which a difference counts as real,
+// roughly p < 0.001 for samples this size
+const Significant = 3.3
+
+// MaxRelative is the largest difference between the means, as a fraction
+// of the slower one, that is tolerated even when it is significant. Costs
+// outside the password check (a map lookup, a redirect) stay well below it,
+// while skipping the hash for one case makes it a small fraction of the other.
+const MaxRelative = 0.1
+
+// Interleave takes n samples of each case. Which goes first is random, so
+// periodic costs like garbage collection don't keep landing on the same
+// side.
+func Interleave(n int, a, b func() time.Duration) (as, bs []time.Duration) {
+	for range n {
+		if rand.IntN(2) == 0 {
+			as = append(as, a())
+			bs = append(bs, b())
+		} else {
+			bs = append(bs, b())
+			as = append(as, a())
+		}
+	}
+	return as, bs
+}
+
+// Comparison is the outcome of comparing two sets of samples
+type Comparison struct {
+	MeanA, MeanB time.Duration
+	// T is Welch's t statistic for the difference between the means
+	T float64
+	// Relative is the difference between the means as a fraction of the
+	// larger one
+	Relative float64
+}
+
+// Compare trims both sets of samples and compares their means
+func Compare(as, bs []time.Duration) Comparison {
+	as, bs = trimmed(as), trimmed(bs)
+	m1, v1 := meanAndVariance(as)
+	m2, v2 := meanAndVariance(bs)
+	return Comparison{
+		MeanA:    time.Duration(m1),
+		MeanB:    time.Duration(m2),
+		T:        (m1 - m2) / math.Sqrt(v1/float64(len(as))+v2/float64(len(bs))),
+		Relative: math.Abs(m1-m2) / math.Max(m1, m2),
+	}
+}
+
+// Differs reports whether the difference is both significant and large
+// enough to measure over a network
+func (c Comparison) Differs() bool {
+	return math.Abs(c.T) > Significant && c.Relative > MaxRelative
+}
+
+func (c Comparison) String() string {
+	return fmt.Sprintf("%v vs %v, t = %.2f, difference %.1f%%", c.MeanA, c.MeanB, c.T, c.Relative*100)
+}
+
+// meanAndVariance returns the sample mean and unbiased variance in
+// nanoseconds
+func meanAndVariance(samples []time.Duration) (mean, variance float64) {
+	for _, s := range samples {
+		mean += float64(s)
+	}
+	mean /= float64(len(samples))
+	for _, s := range samples {
+		d := float64(s) - mean
+		variance += d * d
+	}
+	return mean, variance / float64(len(samples)-1)
+}
+
+// trimmed drops the slowest tenth of the samples, which are mostly the
+// scheduler and garbage collector rather than the code under test
+func trimmed(samples []time.Duration) []time.Duration {
+	sorted := slices.Clone(samples)
+	slices.Sort(sorted)
+	return sorted[:len(sorted)*9/10]
+}
@@ -0,0 +1,69 @@
+package timingstats
+
+import (
+	"context"
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"shared/passhash"
+)
+
+// jittered returns a sampler around base with up to 10% noise
+func jittered(base time.Duration) func() time.Duration {
+	return func() time.Duration {
+		return base + time.Duration(rand.Int64N(int64(base/10)))
+	}
+}
+
+func TestCompare(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		a, b   time.Duration
+		differ bool
+	}{
+		{"same", time.Millisecond, time.Millisecond, false},
+		{"slightly slower", time.Millisecond, 1030 * time.Microsecond, false},
+		{"much faster", time.Millisecond, 100 * time.Microsecond, true},
+		{"quarter slower", time.Millisecond, 1250 * time.Microsecond, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Compare(Interleave(200, jittered(tc.a), jittered(tc.b)))
+			if got.Differs() != tc.differ {
+				t.Errorf("Differs() = %v for %v, want %v", got.Differs(), got, tc.differ)
+			}
+		})
+	}
+}
+
+func TestCompareTrimsOutliers(t *testing.T) {
+	as := make([]time.Duration, 100)
+	bs := make([]time.Duration, 100)
+	for i := range as {
+		as[i] = time.Millisecond + time.Duration(i)*time.Microsecond
+		bs[i] = as[i]
+	}
+	// A few stalls on one side are dropped with the slowest tenth
+	for i := range 5 {
+		bs[i] = time.Second
+	}
+	if got := Compare(as, bs); got.Differs() {
+		t.Errorf("outliers made the samples differ: %v", got)
+	}
+}
+
+func TestCountStartsKeepsTheExistingHook(t *testing.T) {
+	pool := passhash.NewPool(passhash.PoolConfig{Workers: 1})
+	var hooked int
+	pool.OnStart = func(context.Context, time.Duration) { hooked++ }
+	runs := CountStarts(pool)
+
+	for range 3 {
+		if err := pool.Do(context.Background(), func() {}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if runs.Load() != 3 || hooked != 3 {
+		t.Errorf("counted %d starts and the existing hook saw %d, want 3 each", runs.Load(), hooked)
+	}
+}
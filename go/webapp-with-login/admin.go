@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// runCreateAdmin creates the first admin, or promotes an existing user,
+// directly in the state file. Run it while the server is stopped, since the
+// server rewrites the state file on shutdown.
+func runCreateAdmin(args []string) error {
+	cfg, err := loadConfig(nil)
+	if err != nil {
+		return err
+	}
+	config = cfg
+
+	fs := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	username := fs.String("username", "", "admin username")
+	stateFile := fs.String("state-file", config.Store.StateFile, "state file the server loads users from")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *stateFile == "" {
+		return errors.New("a state file is required (-state-file or APP_STATE_FILE), otherwise the admin is lost before the server starts")
+	}
+	// Reserved names are allowed here, as only an operator can run this
+	*username = config.Policy.Username.Canonical(*username)
+	if err := config.Policy.Username.Validate(*username); err != nil {
+		return err
+	}
+	if err := loadState(*stateFile); err != nil {
+		return err
+	}
+
+	action := "promoted"
+	err = users.Update(*username, func(user *Login) { user.Role = RoleAdmin })
+	if errors.Is(err, ErrUserNotFound) {
+		password, err := readAdminPassword()
+		if err != nil {
+			return err
+		}
+		if err := config.Policy.Password.Validate(*username, password); err != nil {
+			return err
+		}
+		hashedPassword, err := hashPassword(password)
+		if err != nil {
+			return fmt.Errorf("hashing password: %w", err)
+		}
+		if err := users.Create(*username, Login{HashedPassword: hashedPassword, Role: RoleAdmin}); err != nil {
+			return err
+		}
+		action = "created"
+	}
+
+	if err := saveState(*stateFile); err != nil {
+		return err
+	}
+	fmt.Printf("%s is now an admin (%s in %s)\n", *username, action, *stateFile)
+	return nil
+}
+
+// readAdminPassword takes the password from ADMIN_PASSWORD, or else reads
+// one line from stdin so it doesn't end up in shell history
+func readAdminPassword() (string, error) {
+	if password, ok := os.LookupEnv("ADMIN_PASSWORD"); ok {
+		return password, nil
+	}
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("reading password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"shared/cli"
+)
+
+// commands are maintenance tasks run as `go run . <name> [flags]` instead of
+// starting the server
+var commands = cli.Commands{
+	"create-admin": {Summary: "create the first admin, or promote a user, in the state file", Run: runCreateAdmin},
+}
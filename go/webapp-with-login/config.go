@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"shared/authpolicy"
+	"shared/passhash"
+	"shared/settings"
+)
+
+// Config holds every tunable setting of the server. Values are resolved in
+// order of precedence: defaults, YAML file, environment variables, CLI flags.
+type Config struct {
+	Server  ServerConfig  `yaml:"server"`
+	Session SessionConfig `yaml:"session"`
+	// Password picks the algorithm new password hashes use. Hashes made
+	// with the other algorithm or older parameters still verify and are
+	// replaced on the user's next successful login.
+	Password passhash.Config   `yaml:"password"`
+	Store    StoreConfig       `yaml:"store"`
+	Policy   authpolicy.Config `yaml:"policy"`
+	Debug    DebugConfig       `yaml:"debug"`
+}
+
+type ServerConfig struct {
+	Addr            string        `yaml:"addr"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+type SessionConfig struct {
+	CookieMaxAge time.Duration `yaml:"cookie_max_age"`
+	TokenLength  int           `yaml:"token_length"`
+}
+
+// StoreConfig controls persistence of the in-memory user map. With no
+// state file, users and sessions are lost when the process exits.
+type StoreConfig struct {
+	StateFile string `yaml:"state_file"`
+}
+
+// DebugConfig protects /debug/vars. Clients must send Token as a bearer
+// token; when it is empty only loopback clients may read it.
+type DebugConfig struct {
+	Token string `yaml:"token"`
+}
+
+// config is the active configuration, set once at startup
+var config = defaultConfig()
+
+func defaultConfig() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Addr:            ":8100",
+			ShutdownTimeout: 25 * time.Second,
+		},
+		Session: SessionConfig{
+			CookieMaxAge: 24 * time.Hour,
+			TokenLength:  64,
+		},
+		Password: passhash.DefaultConfig(),
+		Policy: authpolicy.Config{
+			Username: authpolicy.UsernamePolicy{
+				MinLength:        6,
+				Normalize:        "nfkc",
+				Reserved:         []string{"admin", "administrator", "root", "system", "support", "security", "webmaster"},
+				RejectConfusable: true,
+			},
+			Password: authpolicy.PasswordPolicy{
+				MinLength:      16,
+				Normalize:      "nfkc",
+				RejectUsername: true,
+			},
+		},
+	}
+}
+
+func (cfg *Config) settings() []settings.Setting {
+	return []settings.Setting{
+		settings.String("APP_ADDR", "addr", "listen address", &cfg.Server.Addr),
+		settings.Duration("APP_SHUTDOWN_TIMEOUT", "shutdown-timeout", "how long shutdown may take, draining and hooks included", &cfg.Server.ShutdownTimeout),
+		settings.String("APP_STATE_FILE", "state-file", "file users and sessions are saved to on shutdown", &cfg.Store.StateFile),
+		settings.Duration("APP_SESSION_MAX_AGE", "session-max-age", "lifetime of session cookies", &cfg.Session.CookieMaxAge),
+		settings.String("APP_DEBUG_TOKEN", "debug-token", "bearer token required to read /debug/vars", &cfg.Debug.Token),
+		settings.Int("APP_TOKEN_LENGTH", "token-length", "random bytes in session and CSRF tokens", &cfg.Session.TokenLength),
+		settings.String("APP_PASSWORD_ALGORITHM", "password-algorithm", "hash algorithm for new passwords (argon2id or bcrypt)", &cfg.Password.Algorithm),
+		settings.Int("APP_BCRYPT_COST", "bcrypt-cost", "bcrypt work factor", &cfg.Password.BcryptCost),
+		settings.Int("APP_ARGON2_MEMORY_KIB", "argon2-memory-kib", "argon2id memory in KiB", &cfg.Password.Argon2.MemoryKiB),
+		settings.Int("APP_ARGON2_ITERATIONS", "argon2-iterations", "argon2id passes over memory", &cfg.Password.Argon2.Iterations),
+		settings.Int("APP_ARGON2_PARALLELISM", "argon2-parallelism", "argon2id threads", &cfg.Password.Argon2.Parallelism),
+		settings.Int("APP_HASH_WORKERS", "hash-workers", "password hashes computed at once", &cfg.Password.Pool.Workers),
+		settings.Int("APP_HASH_QUEUE", "hash-queue", "requests that may wait for a hashing worker", &cfg.Password.Pool.Queue),
+		settings.Duration("APP_HASH_QUEUE_TIMEOUT", "hash-queue-timeout", "longest wait for a hashing worker before answering 503", &cfg.Password.Pool.QueueTimeout),
+		settings.Duration("APP_HASH_RETRY_AFTER", "hash-retry-after", "Retry-After sent with 503 when hashing is saturated", &cfg.Password.Pool.RetryAfter),
+		settings.Int("APP_USERNAME_MIN_LENGTH", "username-min-length", "shortest username accepted at registration", &cfg.Policy.Username.MinLength),
+		settings.Int("APP_PASSWORD_MIN_LENGTH", "password-min-length", "shortest password accepted", &cfg.Policy.Password.MinLength),
+		settings.String("APP_PASSWORD_DENY_FILE", "password-deny-file", "file of refused passwords, one per line", &cfg.Policy.Password.DenyFile),
+	}
+}
+
+// loadConfig builds the configuration from defaults, the YAML file given by
+// -config or APP_CONFIG, environment variables and finally CLI flags
+func loadConfig(args []string) (*Config, error) {
+	cfg := defaultConfig()
+	if err := settings.Load(args, cfg, cfg.settings()); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// validate reports every invalid setting at once
+func (cfg *Config) validate() error {
+	var errs []error
+
+	if _, _, err := net.SplitHostPort(cfg.Server.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("server.addr %q must be host:port or :port", cfg.Server.Addr))
+	}
+	if cfg.Server.ShutdownTimeout < time.Second {
+		errs = append(errs, fmt.Errorf("server.shutdown_timeout %s must be at least 1s", cfg.Server.ShutdownTimeout))
+	}
+
+	if cfg.Session.CookieMaxAge < time.Minute {
+		errs = append(errs, fmt.Errorf("session.cookie_max_age %s must be at least 1m", cfg.Session.CookieMaxAge))
+	}
+	if cfg.Session.TokenLength < 16 || cfg.Session.TokenLength > 256 {
+		errs = append(errs, fmt.Errorf("session.token_length %d must be between 16 and 256", cfg.Session.TokenLength))
+	}
+
+	if err := cfg.Password.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	// Also loads the deny file, so a missing one is reported with the rest
+	if err := cfg.Policy.Compile(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// requireDebugAccess guards the debug endpoints. With a token configured
+// clients must send it as a bearer token; without one only loopback
+// clients are allowed. Others get 404, as if the endpoint didn't exist.
+func requireDebugAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !debugAccessAllowed(r) {
+			log.Printf("Debug access denied for %s", r.RemoteAddr)
+			replyError(w, r, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func debugAccessAllowed(r *http.Request) bool {
+	if token := config.Debug.Token; token != "" {
+		supplied, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		return ok && subtle.ConstantTimeCompare([]byte(supplied), []byte(token)) == 1
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
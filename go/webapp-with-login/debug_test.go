@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDebugVarsAccess(t *testing.T) {
+	setupTestStore(t)
+	for _, tc := range []struct {
+		name       string
+		token      string
+		remoteAddr string
+		auth       string
+		want       int
+	}{
+		{"loopback without token", "", "127.0.0.1:4000", "", http.StatusOK},
+		{"remote without token", "", "192.0.2.1:4000", "", http.StatusNotFound},
+		{"remote with token", "s3cret", "192.0.2.1:4000", "Bearer s3cret", http.StatusOK},
+		{"wrong token", "s3cret", "192.0.2.1:4000", "Bearer guess", http.StatusNotFound},
+		{"loopback needs the token once set", "s3cret", "127.0.0.1:4000", "", http.StatusNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config.Debug.Token = tc.token
+			req := httptest.NewRequest(http.MethodGet, "/debug/vars", nil)
+			req.RemoteAddr = tc.remoteAddr
+			if tc.auth != "" {
+				req.Header.Set("Authorization", tc.auth)
+			}
+			rec := httptest.NewRecorder()
+			routes().ServeHTTP(rec, req)
+			if rec.Code != tc.want {
+				t.Errorf("got %d, want %d", rec.Code, tc.want)
+			}
+		})
+	}
+}
@@ -2,4 +2,15 @@ module webapp-with-login
 
 go 1.24.4
 
-require golang.org/x/crypto v0.40.0
+require (
+	golang.org/x/crypto v0.40.0
+	shared v0.0.0
+)
+
+require (
+	golang.org/x/sys v0.34.0 // indirect
+	golang.org/x/text v0.28.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace shared => ../shared
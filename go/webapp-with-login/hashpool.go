@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"shared/passhash"
+)
+
+// Counters for /debug/vars, kept across pool rebuilds
+var hashingStats struct {
+	started   atomic.Int64
+	rejected  atomic.Int64
+	waitNanos atomic.Int64
+}
+
+// newHashPool makes a pool that counts its waits and rejections
+func newHashPool(cfg passhash.PoolConfig) *passhash.Pool {
+	pool := passhash.NewPool(cfg)
+	pool.OnStart = func(_ context.Context, waited time.Duration) {
+		hashingStats.started.Add(1)
+		hashingStats.waitNanos.Add(int64(waited))
+	}
+	pool.OnReject = func(reason string) {
+		// A client that hung up wasn't turned away
+		if reason != "canceled" {
+			hashingStats.rejected.Add(1)
+		}
+	}
+	return pool
+}
+
+// hashing runs every password hash and check made while serving requests
+var hashing = newHashPool(defaultConfig().Password.Pool)
+
+// hashingVars reports the pool's state for /debug/vars
+func hashingVars() any {
+	started := hashingStats.started.Load()
+	var meanWait float64
+	if started > 0 {
+		meanWait = time.Duration(hashingStats.waitNanos.Load() / started).Seconds()
+	}
+	return map[string]any{
+		"workers":           hashing.Workers(),
+		"running":           hashing.Running(),
+		"queue_limit":       hashing.QueueLimit(),
+		"queued":            hashing.Queued(),
+		"started":           started,
+		"rejected":          hashingStats.rejected.Load(),
+		"wait_seconds_mean": meanWait,
+	}
+}
+
+func init() {
+	expvar.Publish("password_hashing", expvar.Func(hashingVars))
+}
+
+// replyHashingFailed answers a request whose password hash didn't run,
+// asking the client to come back later when the pool was saturated
+func replyHashingFailed(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, passhash.ErrBusy) {
+		retryAfter := math.Ceil(config.Password.Pool.RetryAfter.Seconds())
+		w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter)))
+	}
+	replyError(w, r, http.StatusServiceUnavailable, "Server is busy, please try again shortly")
+}
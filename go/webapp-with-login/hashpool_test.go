@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"shared/passhash"
+)
+
+// occupy holds every worker of the pool until the returned func is called
+func occupy(t *testing.T, pool *passhash.Pool) (release func()) {
+	t.Helper()
+	done := make(chan struct{})
+	for range pool.Workers() {
+		started := make(chan struct{})
+		go pool.Do(context.Background(), func() {
+			close(started)
+			<-done
+		})
+		<-started
+	}
+	return func() { close(done) }
+}
+
+func TestLoginWhenHashingIsSaturated(t *testing.T) {
+	setupTestStore(t)
+	hashing = newHashPool(passhash.PoolConfig{Workers: 1})
+	release := occupy(t, hashing)
+	defer release()
+	rejected := hashingStats.rejected.Load()
+
+	rec := postForm(login, "/login", url.Values{"username": {"someone"}, "password": {"a-long-enough-password"}})
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("got Retry-After %q, want 1", got)
+	}
+	if vars := hashingVars().(map[string]any); vars["rejected"] != rejected+1 || vars["running"] != 1 {
+		t.Errorf("got /debug/vars %v, want the rejection counted and one worker running", vars)
+	}
+}
@@ -1,13 +1,31 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"expvar"
+	"flag"
 	"fmt"
+	"log"
 	"net/http"
+	"os"
 	"time"
+
+	"shared/passhash"
+)
+
+// Role controls what a user may do. Users saved before roles existed have
+// an empty role, which counts as RoleUser.
+type Role string
+
+const (
+	RoleUser  Role = "user"
+	RoleAdmin Role = "admin"
 )
 
 type Login struct {
 	HashedPassword string
+	Role           Role
 	SessionToken   string
 	CSRFToken      string
 }
@@ -15,63 +33,114 @@ type Login struct {
 // Username is the key
 type Users map[string]Login
 
-var users = make(Users)
+var users = NewStore()
 
 // Key functions
 func register(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		err := http.StatusMethodNotAllowed
-		http.Error(w, "Invalid method", err)
+	username, password, err := readCredentials(w, r)
+	if err != nil {
+		replyError(w, r, http.StatusBadRequest, "Invalid JSON body")
 		return
 	}
-
-	username := r.FormValue("username")
-	password := r.FormValue("password")
-	if len(username) < 6 || len(password) < 16 {
-		err := http.StatusNotAcceptable
-		http.Error(w, "Invalid username/password", err)
+	if err := config.Policy.Username.Validate(username); err != nil {
+		replyError(w, r, http.StatusNotAcceptable, "Invalid username: "+err.Error())
+		return
+	}
+	if config.Policy.Username.IsReserved(username) {
+		replyError(w, r, http.StatusNotAcceptable, "Invalid username: that name is reserved")
+		return
+	}
+	if err := config.Policy.Password.Validate(username, password); err != nil {
+		replyError(w, r, http.StatusNotAcceptable, "Invalid password: "+err.Error())
 		return
 	}
 
-	if _, ok := users[username]; ok {
-		err := http.StatusConflict
-		http.Error(w, "User already exists", err)
+	if _, ok := users.Get(username); ok {
+		replyError(w, r, http.StatusConflict, "User already exists")
 		return
 	}
 
-	hashedPassword, _ := hashPassword(password)
-	users[username] = Login{
+	var hashedPassword string
+	if poolErr := hashing.Do(r.Context(), func() { hashedPassword, err = hashPassword(password) }); poolErr != nil {
+		replyHashingFailed(w, r, poolErr)
+		return
+	}
+	if errors.Is(err, passhash.ErrPasswordTooLong) {
+		replyError(w, r, http.StatusNotAcceptable, "Password must be at most 72 bytes")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to hash password: %v", err)
+		replyError(w, r, http.StatusInternalServerError, "Registration failed")
+		return
+	}
+	// The name may have been taken while the password was hashing
+	err = users.Create(username, Login{
 		HashedPassword: hashedPassword,
+		Role:           RoleUser,
+	})
+	if errors.Is(err, ErrUsernameConfusable) {
+		replyError(w, r, http.StatusConflict, "Username looks too similar to an existing account")
+		return
+	}
+	if err != nil {
+		replyError(w, r, http.StatusConflict, "User already exists")
+		return
 	}
 
-	fmt.Fprint(w, "Registration successful")
+	// Form posts have always been answered 200; API clients get 201
+	status := http.StatusOK
+	if wantsJSON(r) {
+		status = http.StatusCreated
+	}
+	reply(w, r, status, "Registration successful", nil)
 }
 
 func login(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		err := http.StatusMethodNotAllowed
-		http.Error(w, "Invalid method", err)
+	username, password, err := readCredentials(w, r)
+	if err != nil {
+		replyError(w, r, http.StatusBadRequest, "Invalid JSON body")
 		return
 	}
 
-	username := r.FormValue("username")
-	password := r.FormValue("password")
-
-	user, ok := users[username]
-	if !ok || !checkPasswordMatch(user.HashedPassword, password) {
-		err := http.StatusUnauthorized
-		http.Error(w, "Invalid username or password", err)
+	// Unknown users are checked against a dummy hash, so they take as long
+	// to turn away as a wrong password and can't be told apart by timing
+	user, ok := users.Get(username)
+	if !ok {
+		user.HashedPassword = dummyHash()
+	}
+	var match, needsRehash bool
+	if err := hashing.Do(r.Context(), func() { match, needsRehash = checkPasswordMatch(user.HashedPassword, password) }); err != nil {
+		replyHashingFailed(w, r, err)
 		return
 	}
+	if !ok || !match {
+		replyError(w, r, http.StatusUnauthorized, "Invalid username or password")
+		return
+	}
+	// Upgrade the stored hash while the plaintext is at hand, unless the
+	// pool is too busy; the next login will try again
+	if needsRehash {
+		var rehashed string
+		poolErr := hashing.Do(r.Context(), func() { rehashed, err = hashPassword(password) })
+		if poolErr == nil && err == nil {
+			users.Update(username, func(stored *Login) {
+				// Unless the password was changed in the meantime
+				if stored.HashedPassword == user.HashedPassword {
+					stored.HashedPassword = rehashed
+				}
+			})
+		}
+	}
 
-	sessionToken := generateToken(64)
-	csrfToken := generateToken(64)
+	sessionToken := generateToken(config.Session.TokenLength)
+	csrfToken := generateToken(config.Session.TokenLength)
 
 	// Set session cookie
 	http.SetCookie(w, &http.Cookie{
 		Name:     "session_token",
 		Value:    sessionToken,
-		Expires:  time.Now().Add(24 * time.Hour),
+		Expires:  time.Now().Add(config.Session.CookieMaxAge),
 		HttpOnly: true,
 	})
 
@@ -79,25 +148,23 @@ func login(w http.ResponseWriter, r *http.Request) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     "csrf_token",
 		Value:    csrfToken,
-		Expires:  time.Now().Add(24 * time.Hour),
+		Expires:  time.Now().Add(config.Session.CookieMaxAge),
 		HttpOnly: false,
 	})
 
 	// Store session tokens
-	user.SessionToken = sessionToken
-	user.CSRFToken = csrfToken
-	users[username] = user
-
-	fmt.Fprintln(w, "Login successful")
+	users.Update(username, func(user *Login) {
+		user.SessionToken = sessionToken
+		user.CSRFToken = csrfToken
+	})
 
+	// API clients echo the CSRF token back in the X-CSRF-Token header
+	reply(w, r, http.StatusOK, "Login successful", map[string]any{"csrf_token": csrfToken})
 }
 
+// logout must be wrapped in requireAuth
 func logout(w http.ResponseWriter, r *http.Request) {
-	if err := Authorise(r); err != nil {
-		err := http.StatusUnauthorized
-		http.Error(w, "Unauthorised", err)
-		return
-	}
+	user, _ := CurrentUser(r.Context())
 
 	// Clear cookie
 	http.SetCookie(w, &http.Cookie{
@@ -114,41 +181,71 @@ func logout(w http.ResponseWriter, r *http.Request) {
 		HttpOnly: false,
 	})
 
-	//Clear tokens from db
-	username := r.FormValue("username")
-	user, _ := users[username]
-	user.SessionToken = ""
-	user.CSRFToken = ""
-	users[username] = user
+	// Clear tokens from db for the session's owner only
+	users.EndSession(user.SessionToken)
 
-	fmt.Fprintln(w, "Logged out successfully")
+	reply(w, r, http.StatusOK, "Logged out successfully", nil)
 }
 
-func protected(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		err := http.StatusMethodNotAllowed
-		http.Error(w, "Invalid request method", err)
-		return
-	}
+// policy publishes the username and password rules
+func policy(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, config.Policy)
+}
 
-	if err := Authorise(r); err != nil {
-		err := http.StatusUnauthorized
-		http.Error(w, "Unauthorised", err)
-		return
-	}
+// protected must be wrapped in requireAuth
+func protected(w http.ResponseWriter, r *http.Request) {
+	user, _ := CurrentUser(r.Context())
+	reply(w, r, http.StatusOK, "CSRF validated. Welcome, "+user.Username, map[string]any{"username": user.Username})
+}
 
-	username := r.FormValue("username")
-	fmt.Fprintf(w, "CSRF validated. Welcome, %s", username)
+// routes registers the endpoints. The mux answers other methods with 405
+// and an Allow header.
+func routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /register", register)
+	mux.HandleFunc("POST /login", login)
+	mux.HandleFunc("GET /policy", policy)
+	mux.Handle("GET /debug/vars", requireDebugAccess(expvar.Handler()))
+	mux.Handle("POST /logout", requireAuth(http.HandlerFunc(logout)))
+	mux.Handle("POST /protected", requireAuth(http.HandlerFunc(protected)))
+	return replyMuxErrors(mux)
 }
 
 // Main function
 func main() {
+	if handled, err := commands.Run(os.Args[1:]); handled {
+		if err != nil && !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	// Endpoints
-	http.HandleFunc("/register", register)
-	http.HandleFunc("/login", login)
-	http.HandleFunc("/logout", logout)
-	http.HandleFunc("/protected", protected)
-	http.ListenAndServe(":8100", nil)
+	cfg, err := loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	config = cfg
+	hashing = newHashPool(config.Password.Pool)
+	// Hash the dummy password now rather than on the first unknown login
+	dummyHash()
+
+	// Restore users saved by the previous run and save them again on exit
+	if config.Store.StateFile != "" {
+		if err := loadState(config.Store.StateFile); err != nil {
+			log.Fatalf("Failed to restore state: %v", err)
+		}
+		hooks.OnShutdown("save state", func(context.Context) error {
+			return saveState(config.Store.StateFile)
+		})
+	}
+
+	log.Printf("Listening on %s", config.Server.Addr)
+	if err := runServer(routes()); err != nil {
+		log.Fatal(err)
+	}
 
 }
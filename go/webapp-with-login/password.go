@@ -1,22 +1,42 @@
 package main
 
 import (
-	"golang.org/x/crypto/bcrypt"
+	"log"
+
+	"shared/passhash"
 )
 
-// Hash password using the bcrypt hashing algorithm
+// dummy is verified for unknown usernames
+var dummy passhash.Dummy
+
+// dummyHash returns a hash of a random password nobody knows, made with
+// the configured algorithm and parameters
+func dummyHash() string {
+	hash, err := dummy.Hash(config.Password.Hasher())
+	if err != nil {
+		log.Printf("Failed to create dummy password hash: %v", err)
+	}
+	return hash
+}
+
+// Hash the canonical form of password with the configured algorithm and parameters
 func hashPassword(password string) (string, error) {
-	// Convert password string to byte slice
-	var passwordBytes = []byte(password)
-	// Hash password with bcrypt's DefaultCost(2^10). Alternatives are MinCost(2^10) or MaxCost(2^31)
-	// Alternatively, any other number is also possible
-	hashedPasswordBytes, err := bcrypt.GenerateFromPassword(passwordBytes, bcrypt.DefaultCost)
-	return string(hashedPasswordBytes), err
+	return config.Password.Hasher().Hash(config.Policy.Password.Canonical(password))
 }
 
-// Check if two passwords match using Bcrypt's CompareHashAndPassword
-// which return nil on success and an error on failure.
-func checkPasswordMatch(hashedPassword, currPassword string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(currPassword))
-	return err == nil
+// checkPasswordMatch verifies the password against a hash from any supported
+// algorithm. needsRehash is set when the password matched but the hash was
+// made with another algorithm or outdated parameters, so the caller can
+// store a fresh hash while it has the plaintext.
+func checkPasswordMatch(hashedPassword, currPassword string) (match, needsRehash bool) {
+	current := config.Password.Hasher()
+	canonical := config.Policy.Password.Canonical(currPassword)
+	match, needsRehash = passhash.Verify(current, hashedPassword, canonical)
+	if !match && canonical != currPassword {
+		// Hashed before the password was normalized
+		if match, _ = passhash.Verify(current, hashedPassword, currPassword); match {
+			needsRehash = true
+		}
+	}
+	return match, needsRehash
 }
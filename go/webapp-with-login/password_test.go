@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"shared/passhash"
+)
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	setupTestStore(t)
+
+	encoded, err := hashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("hash %q is not a PHC argon2id string with the configured parameters", encoded)
+	}
+
+	if match, rehash := checkPasswordMatch(encoded, "correct horse battery staple"); !match || rehash {
+		t.Errorf("correct password: match %v, rehash %v; want true, false", match, rehash)
+	}
+	if match, _ := checkPasswordMatch(encoded, "wrong"); match {
+		t.Error("wrong password matched")
+	}
+}
+
+func TestOutdatedHashesNeedRehash(t *testing.T) {
+	setupTestStore(t)
+	const password = "correct horse battery staple"
+
+	config.Password.Algorithm = "bcrypt"
+	bcryptHash, _ := hashPassword(password)
+	config.Password.Algorithm = "argon2id"
+	weakHash, _ := hashPassword(password)
+	config.Password.Argon2.Iterations = 2
+
+	for name, encoded := range map[string]string{"bcrypt": bcryptHash, "old parameters": weakHash} {
+		if match, rehash := checkPasswordMatch(encoded, password); !match || !rehash {
+			t.Errorf("%s: match %v, rehash %v; want true, true", name, match, rehash)
+		}
+	}
+}
+
+func TestBcryptRejectsLongPasswords(t *testing.T) {
+	setupTestStore(t)
+	config.Password.Algorithm = "bcrypt"
+
+	if _, err := hashPassword(strings.Repeat("a", 73)); err != passhash.ErrPasswordTooLong {
+		t.Fatalf("hashing 73 bytes returned %v, want ErrPasswordTooLong", err)
+	}
+}
+
+func TestLoginUpgradesBcryptHash(t *testing.T) {
+	setupTestStore(t)
+	form := url.Values{"username": {"upgrader"}, "password": {"a-long-enough-password"}}
+
+	config.Password.Algorithm = "bcrypt"
+	postForm(register, "/register", form)
+	config.Password.Algorithm = "argon2id"
+
+	postForm(login, "/login", form)
+	user, _ := users.Get("upgrader")
+	if !strings.HasPrefix(user.HashedPassword, "$argon2id$") {
+		t.Fatalf("hash after login is %q, want argon2id", user.HashedPassword)
+	}
+	if match, _ := checkPasswordMatch(user.HashedPassword, "a-long-enough-password"); !match {
+		t.Fatal("upgraded hash doesn't verify")
+	}
+}
+
+func TestMalformedArgon2Hash(t *testing.T) {
+	setupTestStore(t)
+	for _, encoded := range []string{"$argon2id$v=19$m=64,t=1,p=1$!!!$abc", "$argon2id$v=18$m=64,t=1,p=1$YWJj$YWJj", "$argon2id$"} {
+		if match, _ := checkPasswordMatch(encoded, "anything"); match {
+			t.Errorf("malformed hash %q matched", encoded)
+		}
+	}
+}
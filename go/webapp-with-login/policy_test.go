@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"shared/authpolicy"
+)
+
+func TestRegisterAppliesUsernamePolicy(t *testing.T) {
+	setupTestStore(t)
+	password := "a-long-enough-password"
+
+	if rec := postForm(register, "/register", url.Values{"username": {"Admin"}, "password": {password}}); rec.Code != http.StatusNotAcceptable {
+		t.Errorf("reserved username: got %d, want %d", rec.Code, http.StatusNotAcceptable)
+	}
+
+	// The fullwidth form normalizes to the same account under NFKC
+	if rec := postForm(register, "/register", url.Values{"username": {"ｗｉｄｅｕｓｅｒ"}, "password": {password}}); rec.Code != http.StatusOK {
+		t.Fatalf("register: got %d, want %d", rec.Code, http.StatusOK)
+	}
+	if _, ok := users.Get("wideuser"); !ok {
+		t.Fatal("username was not stored in its normalized form")
+	}
+	if rec := postForm(login, "/login", url.Values{"username": {"wideuser"}, "password": {password}}); rec.Code != http.StatusOK {
+		t.Errorf("login with the normalized name: got %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestPolicyEndpoint(t *testing.T) {
+	setupTestStore(t)
+	config.Policy.Password.Deny = []string{"not-for-clients"}
+
+	rec := httptest.NewRecorder()
+	routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/policy", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d, want %d", rec.Code, http.StatusOK)
+	}
+	if strings.Contains(rec.Body.String(), "not-for-clients") {
+		t.Error("deny list was published")
+	}
+
+	var body authpolicy.Config
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Username.MinLength != 6 || body.Password.MinLength != 16 {
+		t.Errorf("got min lengths %d/%d, want 6/16", body.Username.MinLength, body.Password.MinLength)
+	}
+}
+
+func TestUsernamesAreUniqueIgnoringCase(t *testing.T) {
+	setupTestStore(t)
+	password := "a-long-enough-password"
+
+	if rec := postForm(register, "/register", url.Values{"username": {"SomeOne"}, "password": {password}}); rec.Code != http.StatusOK {
+		t.Fatalf("register: got %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := postForm(register, "/register", url.Values{"username": {"someone"}, "password": {password}}); rec.Code != http.StatusConflict {
+		t.Errorf("same name in another case: got %d, want %d", rec.Code, http.StatusConflict)
+	}
+
+	// Logging in in any case finds the account, which keeps its display form
+	rec := postForm(login, "/login", url.Values{"username": {"SOMEONE"}, "password": {password}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: got %d, want %d", rec.Code, http.StatusOK)
+	}
+	if _, ok := users.Snapshot()["SomeOne"]; !ok {
+		t.Error("the name as registered was not kept")
+	}
+}
+
+func TestConfusableUsernamesAreRejected(t *testing.T) {
+	setupTestStore(t)
+	users.Create("paypal-support", Login{})
+
+	for _, lookalike := range []string{"pаypаl-support", "PAYPAL-SUPPORT", "paypa1-support", "pаypal–support"} {
+		if err := users.Create(lookalike, Login{}); err == nil {
+			t.Errorf("%q was created next to paypal-support", lookalike)
+		}
+	}
+
+	users.Create("Alice", Login{})
+	if err := users.Create("AIice", Login{}); err != ErrUsernameConfusable {
+		t.Errorf("AIice next to Alice: got %v, want ErrUsernameConfusable", err)
+	}
+
+	config.Policy.Username.RejectConfusable = false
+	if err := users.Create("pаypаl-support", Login{}); err != nil {
+		t.Errorf("with reject_confusable off: %v", err)
+	}
+}
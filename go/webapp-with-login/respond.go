@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Credential bodies are tiny; anything bigger is not a real client
+const maxJSONBodyBytes = 4 << 10
+
+var errInvalidJSON = errors.New("invalid JSON body")
+
+// wantsJSON reports whether the client should get JSON back: when its Accept
+// header ranks application/json above text/plain, or it sent JSON and
+// didn't say what it accepts. Form posts and browsers get plain text.
+func wantsJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return isJSONRequest(r)
+	}
+
+	jsonQ, textQ, anyQ := -1.0, -1.0, -1.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		switch mediaType {
+		case "application/json", "application/*":
+			jsonQ = max(jsonQ, q)
+		case "text/plain", "text/*":
+			textQ = max(textQ, q)
+		case "*/*":
+			anyQ = max(anyQ, q)
+		}
+	}
+	if jsonQ < 0 && textQ < 0 {
+		// Anything goes, so answer in the format the request was sent in
+		return anyQ > 0 && isJSONRequest(r)
+	}
+	return jsonQ > 0 && jsonQ > textQ
+}
+
+func isJSONRequest(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/json"
+}
+
+// readCredentials takes the username and password from a JSON body or
+// form, with the username in its canonical form
+func readCredentials(w http.ResponseWriter, r *http.Request) (string, string, error) {
+	if !isJSONRequest(r) {
+		return config.Policy.Username.Canonical(r.FormValue("username")), r.FormValue("password"), nil
+	}
+
+	var body struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJSONBodyBytes))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		return "", "", errInvalidJSON
+	}
+	return config.Policy.Username.Canonical(body.Username), body.Password, nil
+}
+
+// reply sends a success message as plain text, or as {"message": ...} plus
+// any extra fields to JSON clients
+func reply(w http.ResponseWriter, r *http.Request, status int, message string, extra map[string]any) {
+	if !wantsJSON(r) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		fmt.Fprintln(w, message)
+		return
+	}
+
+	body := map[string]any{"message": message}
+	for key, value := range extra {
+		body[key] = value
+	}
+	writeJSON(w, status, body)
+}
+
+// replyError sends an error as plain text, or as {"error": ...} to JSON clients
+func replyError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if !wantsJSON(r) {
+		http.Error(w, message, status)
+		return
+	}
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// muxError keeps the status and headers the mux would send for a request
+// it has no route for, dropping its plain text body
+type muxError struct {
+	header http.Header
+	status int
+}
+
+func (m *muxError) Header() http.Header         { return m.header }
+func (m *muxError) WriteHeader(status int)      { m.status = status }
+func (m *muxError) Write(b []byte) (int, error) { return len(b), nil }
+
+// replyMuxErrors answers the mux's own 404 and 405 replies through
+// replyError, so JSON clients get JSON for those too
+func replyMuxErrors(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler, pattern := mux.Handler(r)
+		if pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		failed := &muxError{header: http.Header{}, status: http.StatusOK}
+		handler.ServeHTTP(failed, r)
+		if failed.status < http.StatusBadRequest {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		if allow := failed.header.Get("Allow"); allow != "" {
+			w.Header().Set("Allow", allow)
+		}
+		replyError(w, r, failed.status, http.StatusText(failed.status))
+	})
+}
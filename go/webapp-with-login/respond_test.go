@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWantsJSON(t *testing.T) {
+	tests := []struct {
+		accept      string
+		contentType string
+		want        bool
+	}{
+		{"", "", false},
+		{"", "application/x-www-form-urlencoded", false},
+		{"", "application/json", true},
+		{"application/json", "", true},
+		{"text/plain", "application/json", false},
+		{"text/html,application/xhtml+xml,*/*;q=0.8", "application/x-www-form-urlencoded", false},
+		{"*/*", "application/json", true},
+		{"text/plain;q=0.5, application/json", "", true},
+		{"application/json;q=0", "", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		if got := wantsJSON(req); got != tt.want {
+			t.Errorf("wantsJSON(Accept %q, Content-Type %q) = %v, want %v", tt.accept, tt.contentType, got, tt.want)
+		}
+	}
+}
+
+func serveJSON(t *testing.T, handler http.Handler, path, body string, cookies ...*http.Cookie) (*httptest.ResponseRecorder, map[string]string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	var decoded map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &decoded); err != nil {
+		t.Fatalf("%s: response is not JSON: %q", path, rec.Body.String())
+	}
+	return rec, decoded
+}
+
+func TestJSONAPI(t *testing.T) {
+	setupTestStore(t)
+	mux := routes()
+	credentials := `{"username":"apiclient","password":"a-long-enough-password"}`
+
+	if rec, body := serveJSON(t, mux, "/register", credentials); rec.Code != http.StatusCreated || body["message"] == "" {
+		t.Fatalf("register returned %d %v", rec.Code, body)
+	}
+	if rec, body := serveJSON(t, mux, "/register", credentials); rec.Code != http.StatusConflict || body["error"] == "" {
+		t.Fatalf("second register returned %d %v", rec.Code, body)
+	}
+	if rec, body := serveJSON(t, mux, "/login", `{"username":"apiclient"`); rec.Code != http.StatusBadRequest || body["error"] == "" {
+		t.Fatalf("malformed login returned %d %v", rec.Code, body)
+	}
+
+	rec, body := serveJSON(t, mux, "/login", credentials)
+	if rec.Code != http.StatusOK || body["csrf_token"] == "" {
+		t.Fatalf("login returned %d %v", rec.Code, body)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/protected", nil)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-CSRF-Token", body["csrf_token"])
+	for _, cookie := range rec.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	protectedRec := httptest.NewRecorder()
+	mux.ServeHTTP(protectedRec, req)
+	if !strings.Contains(protectedRec.Body.String(), `"username":"apiclient"`) {
+		t.Fatalf("protected returned %d %q", protectedRec.Code, protectedRec.Body.String())
+	}
+
+	if rec, body := serveJSON(t, mux, "/logout", ""); rec.Code != http.StatusUnauthorized || body["error"] == "" {
+		t.Fatalf("unauthenticated logout returned %d %v", rec.Code, body)
+	}
+}
+
+func TestRoutesRejectOtherMethods(t *testing.T) {
+	rec := httptest.NewRecorder()
+	routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/login", nil))
+
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "POST" {
+		t.Fatalf("GET /login returned %d with Allow %q", rec.Code, rec.Header().Get("Allow"))
+	}
+}
+
+func TestMuxErrorsGetJSON(t *testing.T) {
+	for _, tt := range []struct {
+		method, path string
+		status       int
+	}{
+		{http.MethodPost, "/no-such-page", http.StatusNotFound},
+		{http.MethodGet, "/login", http.StatusMethodNotAllowed},
+	} {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		req.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		routes().ServeHTTP(rec, req)
+
+		var body map[string]string
+		if rec.Code != tt.status || json.Unmarshal(rec.Body.Bytes(), &body) != nil || body["error"] == "" {
+			t.Errorf("%s %s returned %d %q", tt.method, tt.path, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestFormPostsGetPlainText(t *testing.T) {
+	setupTestStore(t)
+
+	rec := postForm(routes().ServeHTTP, "/login", nil)
+	if rec.Code != http.StatusUnauthorized || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("form login returned %d with %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
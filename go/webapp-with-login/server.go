@@ -0,0 +1,24 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"shared/server"
+)
+
+// hooks holds the work done once the server has drained, such as saving
+// state
+var hooks server.Hooks
+
+// runServer serves handler until SIGINT, SIGTERM or a listener failure, then
+// drains in-flight requests and runs the shutdown hooks within
+// shutdown_timeout
+func runServer(handler http.Handler) error {
+	return server.Run(handler, server.Options{
+		Addr:            config.Server.Addr,
+		ShutdownTimeout: config.Server.ShutdownTimeout,
+		Hooks:           &hooks,
+		Logger:          slog.Default(),
+	})
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
 	"errors"
 	"log"
@@ -17,26 +19,59 @@ func generateToken(length int) string {
 	return base64.URLEncoding.EncodeToString(bytes)
 }
 
+// tokensEqual compares secrets in constant time, so a guess can't be
+// refined byte by byte from how quickly it is rejected
+func tokensEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
 var AuthError = errors.New("Unauthorised")
 
-func Authorise(r *http.Request) error {
-	username := r.FormValue("username")
-	user, ok := users[username]
-	if !ok {
-		return AuthError
-	}
+// User is an authenticated user, as resolved from their session
+type User struct {
+	Username string
+	Login
+}
 
-	// Get and validate session token from cookie
+// Authorise checks the session cookie and the CSRF token header and returns
+// the session's user. The user is found through the session index, never
+// from anything else in the request.
+func Authorise(r *http.Request) (User, error) {
 	st, err := r.Cookie("session_token")
-	if err != nil || st.Value != user.SessionToken {
-		return AuthError
+	if err != nil {
+		return User{}, AuthError
+	}
+	username, user, ok := users.FindBySession(st.Value)
+	if !ok {
+		return User{}, AuthError
 	}
 
 	// Get CSRF token from the header
 	csrf := r.Header.Get("X-CSRF-Token")
-	if csrf != user.CSRFToken || csrf == "" {
-		return AuthError
+	if csrf == "" || !tokensEqual(csrf, user.CSRFToken) {
+		return User{}, AuthError
 	}
 
-	return nil
+	return User{Username: username, Login: user}, nil
+}
+
+type userContextKey struct{}
+
+// requireAuth only lets authorised requests through to next, with the user
+// stored in the request context for CurrentUser
+func requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := Authorise(r)
+		if err != nil {
+			replyError(w, r, http.StatusUnauthorized, "Unauthorised")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, user)))
+	})
+}
+
+// CurrentUser returns the user stored by requireAuth
+func CurrentUser(ctx context.Context) (User, bool) {
+	user, ok := ctx.Value(userContextKey{}).(User)
+	return user, ok
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestRequireAuthStoresUserInContext(t *testing.T) {
+	setupTestStore(t)
+
+	form := url.Values{"username": {"someone"}, "password": {"a-long-enough-password"}}
+	postForm(register, "/register", form)
+	cookies := postForm(login, "/login", form).Result().Cookies()
+
+	var got User
+	var found bool
+	handler := requireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, found = CurrentUser(r.Context())
+	}))
+
+	if rec := postForm(handler.ServeHTTP, "/", nil, cookies...); rec.Code != http.StatusOK {
+		t.Fatalf("authorised request returned %d", rec.Code)
+	}
+	if !found || got.Username != "someone" || got.SessionToken == "" {
+		t.Fatalf("CurrentUser = %+v, %v, want someone with a session", got, found)
+	}
+}
+
+func TestRequireAuthRejectsMissingCSRFToken(t *testing.T) {
+	setupTestStore(t)
+
+	form := url.Values{"username": {"someone"}, "password": {"a-long-enough-password"}}
+	postForm(register, "/register", form)
+	var session []*http.Cookie
+	for _, cookie := range postForm(login, "/login", form).Result().Cookies() {
+		if cookie.Name == "session_token" {
+			session = append(session, cookie)
+		}
+	}
+
+	called := false
+	handler := requireAuth(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		called = true
+	}))
+
+	if rec := postForm(handler.ServeHTTP, "/", nil, session...); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("request without CSRF header returned %d, want 401", rec.Code)
+	}
+	if called {
+		t.Fatal("handler ran for an unauthorised request")
+	}
+}
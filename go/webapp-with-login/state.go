@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// loadState restores the users (and their sessions) saved by a previous run.
+// A missing file is not an error, it just means there is nothing to restore.
+func loadState(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading state file: %w", err)
+	}
+
+	loaded := make(Users)
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return fmt.Errorf("parsing state file %s: %w", path, err)
+	}
+
+	if err := users.Replace(loaded); err != nil {
+		return fmt.Errorf("loading state file %s: %w", path, err)
+	}
+
+	log.Printf("Restored %d users from %s", len(loaded), path)
+	return nil
+}
+
+// saveState writes the users to path, replacing it atomically so a crash
+// mid-write never leaves a truncated file behind
+func saveState(path string) error {
+	snapshot := users.Snapshot()
+	data, err := json.Marshal(snapshot)
+	count := len(snapshot)
+	if err != nil {
+		return fmt.Errorf("encoding state: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".state-*")
+	if err != nil {
+		return fmt.Errorf("creating state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	// Password hashes and session tokens live in here, so keep it private
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("creating state file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("replacing state file: %w", err)
+	}
+
+	log.Printf("Saved %d users to %s", count, path)
+	return nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"shared/authpolicy"
+)
+
+var (
+	ErrUserExists         = errors.New("user already exists")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrUsernameConfusable = errors.New("username looks like an existing one")
+)
+
+// Store is a concurrency-safe set of users. net/http serves every request
+// on its own goroutine, so the map must never be touched without the lock.
+// Logins are returned by value, so callers can't modify stored entries.
+//
+// sessions indexes usernames by session token, so a request's user is found
+// from its session cookie rather than anything else the client sends. The
+// index is keyed by a digest of the token, so how long a lookup takes says
+// nothing about how close a guessed token came.
+//
+// Users stay keyed by the name as registered. names maps each userKey to
+// that name, so lookups in any case find the same user, and skeletons maps
+// each of the user's authpolicy.Skeletons to it, to catch lookalike names.
+type Store struct {
+	mu        sync.RWMutex
+	users     Users
+	sessions  map[[sha256.Size]byte]string
+	names     map[string]string
+	skeletons map[string]string
+}
+
+func NewStore() *Store {
+	return &Store{
+		users:     make(Users),
+		sessions:  make(map[[sha256.Size]byte]string),
+		names:     make(map[string]string),
+		skeletons: make(map[string]string),
+	}
+}
+
+func sessionKey(sessionToken string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(sessionToken))
+}
+
+// userKey is the case-folded identity of a username
+func userKey(username string) string {
+	return config.Policy.Username.Key(username)
+}
+
+// resolve returns the registered name for username written in any case.
+// Callers hold the lock.
+func (s *Store) resolve(username string) string {
+	if name, ok := s.names[userKey(username)]; ok {
+		return name
+	}
+	return username
+}
+
+// put stores the user and keeps the indexes in step. Callers hold the lock.
+func (s *Store) put(username string, user Login) {
+	if old, ok := s.users[username]; ok && old.SessionToken != user.SessionToken {
+		delete(s.sessions, sessionKey(old.SessionToken))
+	}
+	if user.SessionToken != "" {
+		s.sessions[sessionKey(user.SessionToken)] = username
+	}
+	s.users[username] = user
+	s.names[userKey(username)] = username
+	for _, skeleton := range authpolicy.Skeletons(username) {
+		s.skeletons[skeleton] = username
+	}
+}
+
+// Get returns the user's login details
+func (s *Store) Get(username string) (Login, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[s.resolve(username)]
+	return user, ok
+}
+
+// Create adds a user, failing with ErrUserExists if the name is taken in
+// any case, or ErrUsernameConfusable if it looks like another user's name
+func (s *Store) Create(username string, user Login) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.names[userKey(username)]; ok {
+		return ErrUserExists
+	}
+	if config.Policy.Username.RejectConfusable {
+		for _, skeleton := range authpolicy.Skeletons(username) {
+			if _, ok := s.skeletons[skeleton]; ok {
+				return ErrUsernameConfusable
+			}
+		}
+	}
+	s.put(username, user)
+	return nil
+}
+
+// Update applies fn to the user under the lock
+func (s *Store) Update(username string, fn func(*Login)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	username = s.resolve(username)
+	user, ok := s.users[username]
+	if !ok {
+		return ErrUserNotFound
+	}
+	fn(&user)
+	s.put(username, user)
+	return nil
+}
+
+// FindBySession returns the user owning the session token
+func (s *Store) FindBySession(sessionToken string) (string, Login, bool) {
+	if sessionToken == "" {
+		return "", Login{}, false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	username, ok := s.sessions[sessionKey(sessionToken)]
+	if !ok {
+		return "", Login{}, false
+	}
+	return username, s.users[username], true
+}
+
+// EndSession clears the session and CSRF tokens of whoever owns the session
+// token. Unknown tokens are ignored.
+func (s *Store) EndSession(sessionToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	username, ok := s.sessions[sessionKey(sessionToken)]
+	if !ok {
+		return
+	}
+	user := s.users[username]
+	user.SessionToken = ""
+	user.CSRFToken = ""
+	s.put(username, user)
+}
+
+// Len returns the number of users
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.users)
+}
+
+// Snapshot returns a copy of every user, for saving the state file
+func (s *Store) Snapshot() Users {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snapshot := make(Users, len(s.users))
+	for username, user := range s.users {
+		snapshot[username] = user
+	}
+	return snapshot
+}
+
+// Replace swaps in a full set of users, for restoring the state file.
+// Names that differ only by case can't both log in, so a set holding any
+// is refused whole and the store is left as it was.
+func (s *Store) Replace(users Users) error {
+	names := make(map[string]string, len(users))
+	var collisions []string
+	for _, username := range slices.Sorted(maps.Keys(users)) {
+		if other, taken := names[userKey(username)]; taken {
+			collisions = append(collisions, fmt.Sprintf("%q and %q", other, username))
+			continue
+		}
+		names[userKey(username)] = username
+	}
+	if len(collisions) > 0 {
+		return fmt.Errorf("saved usernames differ only by case: %s; rename or remove one of each", strings.Join(collisions, ", "))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users = make(Users, len(users))
+	s.sessions = make(map[[sha256.Size]byte]string)
+	s.names = make(map[string]string)
+	s.skeletons = make(map[string]string)
+	for _, username := range slices.Sorted(maps.Keys(users)) {
+		s.put(username, users[username])
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// These tests are meant to be run with the race detector: go test -race ./...
+
+func setupTestStore(t *testing.T) {
+	t.Helper()
+	users = NewStore()
+	config = defaultConfig()
+	config.Password.BcryptCost = bcrypt.MinCost
+	// Cheap parameters so tests under the race detector stay fast
+	config.Password.Argon2.MemoryKiB = 64
+	config.Password.Argon2.Iterations = 1
+	hashing = newHashPool(config.Password.Pool)
+}
+
+func postForm(handler http.HandlerFunc, path string, form url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+		if cookie.Name == "csrf_token" {
+			req.Header.Set("X-CSRF-Token", cookie.Value)
+		}
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+func TestStoreCreateIsAtomic(t *testing.T) {
+	store := NewStore()
+
+	const attempts = 64
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created := 0
+	for i := range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := store.Create("someone", Login{HashedPassword: fmt.Sprint(i)}); err == nil {
+				mu.Lock()
+				created++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if created != 1 {
+		t.Fatalf("created %d users with the same name, want 1", created)
+	}
+	if store.Len() != 1 {
+		t.Fatalf("store has %d users, want 1", store.Len())
+	}
+}
+
+func TestStoreReturnsCopies(t *testing.T) {
+	store := NewStore()
+	if err := store.Create("someone", Login{SessionToken: "original"}); err != nil {
+		t.Fatal(err)
+	}
+
+	user, _ := store.Get("someone")
+	user.SessionToken = "changed"
+	snapshot := store.Snapshot()
+	snapshot["someone"] = Login{SessionToken: "changed"}
+
+	if user, _ := store.Get("someone"); user.SessionToken != "original" {
+		t.Fatalf("stored session token is %q, want it unchanged", user.SessionToken)
+	}
+}
+
+func TestStoreUpdateMissingUser(t *testing.T) {
+	store := NewStore()
+	if err := store.Update("nobody", func(*Login) {}); err != ErrUserNotFound {
+		t.Fatalf("Update returned %v, want ErrUserNotFound", err)
+	}
+}
+
+func TestStoreReplaceRefusesCaseCollisions(t *testing.T) {
+	store := NewStore()
+	if err := store.Create("someone", Login{}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := store.Replace(Users{"Alice": {}, "alice": {}, "bob": {}})
+	if err == nil || !strings.Contains(err.Error(), `"Alice" and "alice"`) {
+		t.Fatalf("Replace returned %v, want an error naming both users", err)
+	}
+	if _, ok := store.Get("someone"); !ok {
+		t.Fatal("a refused Replace changed the store")
+	}
+}
+
+func TestConcurrentRegistrationOfSameUsername(t *testing.T) {
+	setupTestStore(t)
+
+	const attempts = 32
+	codes := make(chan int, attempts)
+	var wg sync.WaitGroup
+	for range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := postForm(register, "/register", url.Values{
+				"username": {"contested"},
+				"password": {"a-long-enough-password"},
+			})
+			codes <- rec.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	succeeded := 0
+	for code := range codes {
+		switch code {
+		case http.StatusOK:
+			succeeded++
+		case http.StatusConflict:
+		default:
+			t.Errorf("unexpected status %d", code)
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("%d registrations succeeded, want 1", succeeded)
+	}
+}
+
+func TestConcurrentRegistrationsAndLogins(t *testing.T) {
+	setupTestStore(t)
+
+	const clients = 16
+	const password = "a-long-enough-password"
+
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			username := fmt.Sprintf("client%02d", i)
+			form := url.Values{"username": {username}, "password": {password}}
+
+			if rec := postForm(register, "/register", form); rec.Code != http.StatusOK {
+				t.Errorf("%s: register returned %d", username, rec.Code)
+				return
+			}
+			for range 4 {
+				rec := postForm(login, "/login", form)
+				if rec.Code != http.StatusOK {
+					t.Errorf("%s: login returned %d", username, rec.Code)
+					return
+				}
+				rec = postForm(requireAuth(http.HandlerFunc(protected)).ServeHTTP, "/protected", url.Values{"username": {username}}, rec.Result().Cookies()...)
+				if rec.Code != http.StatusOK {
+					t.Errorf("%s: protected returned %d", username, rec.Code)
+				}
+			}
+		}()
+	}
+
+	// Saving state while requests are in flight must not race either
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				_ = users.Snapshot()
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(stop)
+	<-done
+
+	if users.Len() != clients {
+		t.Fatalf("store has %d users, want %d", users.Len(), clients)
+	}
+	for i := range clients {
+		username := fmt.Sprintf("client%02d", i)
+		user, _ := users.Get(username)
+		if user.SessionToken == "" || user.CSRFToken == "" {
+			t.Errorf("%s has no session after logging in", username)
+		}
+	}
+}
+
+func TestSessionIndexFollowsLogins(t *testing.T) {
+	store := NewStore()
+	if err := store.Create("someone", Login{}); err != nil {
+		t.Fatal(err)
+	}
+
+	store.Update("someone", func(user *Login) { user.SessionToken = "first" })
+	store.Update("someone", func(user *Login) { user.SessionToken = "second" })
+
+	if _, _, ok := store.FindBySession("first"); ok {
+		t.Error("replaced session token still resolves")
+	}
+	if username, _, ok := store.FindBySession("second"); !ok || username != "someone" {
+		t.Errorf("FindBySession(second) = %q, %v, want someone", username, ok)
+	}
+
+	store.EndSession("second")
+	if _, _, ok := store.FindBySession("second"); ok {
+		t.Error("ended session still resolves")
+	}
+}
+
+func TestLogoutOnlyEndsOwnSession(t *testing.T) {
+	setupTestStore(t)
+
+	sessions := make(map[string][]*http.Cookie)
+	for _, username := range []string{"alice1", "mallory"} {
+		form := url.Values{"username": {username}, "password": {"a-long-enough-password"}}
+		postForm(register, "/register", form)
+		sessions[username] = postForm(login, "/login", form).Result().Cookies()
+	}
+
+	// The username field names someone else, then someone who doesn't exist
+	for _, victim := range []string{"alice1", "nobody"} {
+		rec := postForm(requireAuth(http.HandlerFunc(logout)).ServeHTTP, "/logout", url.Values{"username": {victim}}, sessions["mallory"]...)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("logout returned %d", rec.Code)
+		}
+		form := url.Values{"username": {"mallory"}, "password": {"a-long-enough-password"}}
+		sessions["mallory"] = postForm(login, "/login", form).Result().Cookies()
+	}
+	postForm(requireAuth(http.HandlerFunc(logout)).ServeHTTP, "/logout", nil, sessions["mallory"]...)
+
+	if user, _ := users.Get("alice1"); user.SessionToken == "" {
+		t.Error("logging out mallory ended alice1's session")
+	}
+	if user, _ := users.Get("mallory"); user.SessionToken != "" {
+		t.Error("mallory is still logged in")
+	}
+	if _, ok := users.Get("nobody"); ok {
+		t.Error("logout created an entry for an unknown username")
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"shared/timingstats"
+)
+
+// setupTimingStore registers one user and uses hashing parameters heavy
+// enough that password checks, not request handling, dominate a login
+func setupTimingStore(tb testing.TB) {
+	tb.Helper()
+	users = NewStore()
+	config = defaultConfig()
+	config.Password.Argon2.MemoryKiB = 1024
+	config.Password.Argon2.Iterations = 1
+	hashing = newHashPool(config.Password.Pool)
+	hashRuns = timingstats.CountStarts(hashing)
+
+	hashed, err := hashPassword("a-long-enough-password")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	users.Create("someone", Login{HashedPassword: hashed, Role: RoleUser})
+	dummyHash()
+}
+
+// hashRuns counts the jobs the hash pool has started
+var hashRuns *atomic.Int64
+
+func timeLogin(username string) time.Duration {
+	form := url.Values{"username": {username}, "password": {"not-the-password"}}
+	start := time.Now()
+	rec := postForm(login, "/login", form)
+	elapsed := time.Since(start)
+	if rec.Code != http.StatusUnauthorized {
+		panic("login with a wrong password was not refused")
+	}
+	return elapsed
+}
+
+// A wrong password for an existing user and any password for an unknown
+// one should take the same time, or timing tells which usernames exist
+func TestLoginTimingParity(t *testing.T) {
+	setupTimingStore(t)
+	timingstats.AssertParity(t, 200,
+		func() time.Duration { return timeLogin("someone") },
+		func() time.Duration { return timeLogin("nobody-at-all") })
+}
+
+// Timing alone can miss a login that skips the hash when it is cheap, so
+// count the password checks too: every refusal costs exactly one
+func TestLoginHashesOnce(t *testing.T) {
+	setupTimingStore(t)
+	for _, username := range []string{"someone", "nobody-at-all"} {
+		before := hashRuns.Load()
+		timeLogin(username)
+		if runs := hashRuns.Load() - before; runs != 1 {
+			t.Errorf("login as %q ran %d password hashes, want 1", username, runs)
+		}
+	}
+}
+
+func BenchmarkLogin(b *testing.B) {
+	setupTimingStore(b)
+	for _, bench := range []struct {
+		name     string
+		username string
+	}{
+		{"existing", "someone"},
+		{"missing", "nobody-at-all"},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			for b.Loop() {
+				timeLogin(bench.username)
+			}
+		})
+	}
+}